@@ -1,83 +1,115 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/twoflyliu/novel/engine"
-	_ "github.com/twoflyliu/novel/extracter"
+	extracter "github.com/twoflyliu/novel/extracter"
 )
 
 func main() {
-	var download, update, verbose, downloadIcon bool
-	var downloadDir string
-	var iconExt string
-	var iconDir string
-	var novelExt string
-	var logDir string
+	var download, update, repair, downloadIcon bool
+	var timeout time.Duration
+	var ndjson bool
 
 	flag.BoolVar(&download, "g", false, "do download operator")
 	flag.BoolVar(&downloadIcon, "gi", false, "if download icon")
 
 	flag.BoolVar(&update, "u", false, "do update operator")
-	flag.StringVar(&downloadDir, "d", "./json", "the directory of download object")
-	flag.StringVar(&novelExt, "e", "", "the ext name of novel")
-	flag.BoolVar(&verbose, "verbose", false, "enable debug information")
-	flag.StringVar(&iconExt, "ie", "img", "icon ext name")
-	flag.StringVar(&iconDir, "id", "icons", "icon native directory")
-	flag.StringVar(&logDir, "ld", ".", "log dir name")
+	flag.BoolVar(&repair, "r", false, "download the failed or empty chapters of native novel again")
+	flag.DurationVar(&timeout, "t", 0, "give up the operator after the duration, 0 means no limit")
+	flag.BoolVar(&ndjson, "ndjson", false, "print progress events as newline delimited json")
+	configFlags := engine.RegisterConfigFlags(flag.CommandLine, engine.FileConfig{NovelDir: "./json",
+		IconDir: "icons", IconSuffix: "img", BaseDir: ".", WorkerCount: engine.THREAD_COUNT})
 	flag.Parse()
 
 	// 默认是下载操作
-	if !download && !update {
+	if !download && !update && !repair {
 		download = true
 	}
 
 	if flag.NArg() != 1 {
-		fmt.Fprintf(os.Stderr, "Usage: %s -[g|u] [-d dirname] novel_name\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s -[g|u|r] [-c config] [-d dirname] novel_name\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if len(logDir) > 1 && logDir[len(logDir)-1] == '/' {
-		logDir = logDir[0 : len(logDir)-1]
+	// 前端结束后台进程的时候，可以让正在进行的下载尽快结束
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	mgr := engine.NewDefaultEngine(verbose, downloadDir, novelExt, iconDir, iconExt, logDir)
+
+	progressHandler := newTextProgressHandler(os.Stdout)
+	if ndjson {
+		progressHandler = newNDJSONProgressHandler(os.Stdout)
+	}
+	cfg, err := configFlags.Config()
+	CheckError(err)
+	mgr := engine.NewEngine(append(cfg.Options(), engine.WithProgressHandler(progressHandler))...)
+
+	// 下载的时候修改sites.json或者收到SIGHUP，重新加载网站的配置
+	watcher := extracter.NewSitesConfigWatcher(cfg.SitesConfig, cfg.BaseDir)
+	source, err := watcher.Reload()
+	CheckError(err)
+	mgr.GetLogger().Debugf("Load sites from %s", source)
+	go watcher.Watch(ctx, extracter.DEFAULT_WATCH_INTERVAL)
+	go watcher.ReloadOnSignal(ctx, syscall.SIGHUP)
 	switch {
 	case update:
-		doUpdate(mgr, flag.Arg(0))
+		doUpdate(ctx, mgr, flag.Arg(0))
+	case repair:
+		doRepair(ctx, mgr, flag.Arg(0))
 	case download:
-		doDownload(mgr, flag.Arg(0), downloadDir, iconExt, downloadIcon)
+		doDownload(ctx, mgr, flag.Arg(0), downloadIcon)
 	}
 }
 
-func doUpdate(mgr *engine.Engine, novelName string) {
+func doUpdate(ctx context.Context, mgr *engine.Engine, novelName string) {
 	logger := mgr.GetLogger()
 	logger.Debugf("Update novel %q", novelName)
 
 	// 下面是从本地加载文件，但是如果本地没有对应的novel，他会自动下载的
-	novel, err := mgr.NovelByName(novelName)
+	novel, err := mgr.NovelByName(ctx, novelName)
+	CheckError(err)
+	report, err := mgr.SyncNovel(ctx, novel) //手动更新
 	CheckError(err)
-	mgr.SyncNovel(novel) //手动更新
+	for _, change := range append(append(report.Added, report.Changed...), report.Removed...) {
+		logger.Infof("%s: %v -> %v", change.Kind, change.Old, change.New)
+	}
 }
 
-func doDownload(mgr *engine.Engine, url string, dirname string, iconExt string,
-	downloadIcon bool) {
+func doRepair(ctx context.Context, mgr *engine.Engine, novelName string) {
 	logger := mgr.GetLogger()
-	novel, err := mgr.NovelByURL(url)
+	logger.Debugf("Repair novel %q", novelName)
+
+	novel, err := mgr.LoadNovel(novelName)
+	CheckError(err)
+	repaired, err := mgr.RepairNovel(ctx, novel)
+	CheckError(err)
+	logger.Infof("Repair %d chapters of novel %q", repaired, novelName)
+}
+
+func doDownload(ctx context.Context, mgr *engine.Engine, url string, downloadIcon bool) {
+	logger := mgr.GetLogger()
+	novel, err := mgr.NovelByURL(ctx, url)
 	CheckError(err)
 	logger.Debugf("Download novel to memory done!")
 	err = mgr.SaveNovel(novel)
 	CheckError(err)
 	logger.Debugf("Save novel to native done!")
 
-	if len(iconExt) > 0 && iconExt[0] != '.' {
-		iconExt = "." + iconExt
-	}
-
 	if downloadIcon {
-		mgr.DownloadAndSaveIcon(novel)
+		mgr.DownloadAndSaveIcon(ctx, novel)
 		logger.Debugf("Download and save icon to native done!")
 	}
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+// newTextProgressHandler 输出和以前版本一样的文本格式，novel/app.py通过每行开头的<%百分比>来获取进度
+func newTextProgressHandler(w io.Writer) engine.ProgressHandler {
+	return func(event engine.ProgressEvent) {
+		switch event.Type {
+		case engine.EventStarted:
+			if event.Op == engine.OpUpdate {
+				fmt.Fprintf(w, "Begining update: %s\n", event.Novel)
+			}
+		case engine.EventChapterSucceeded:
+			fmt.Fprintf(w, "<%%%.1f>[%d] Successfully download(%s) chapter %q\n",
+				event.Percent, event.Worker, event.Novel, event.Chapter)
+		case engine.EventChapterFailed:
+			fmt.Fprintf(w, "<%%%.1f>[%d] Dowloader.downloader(%s) fail[%s]: %s\n",
+				event.Percent, event.Worker, event.Novel, event.URL, event.Error)
+		case engine.EventFinished:
+			if event.Op == engine.OpUpdate {
+				fmt.Fprintf(w, "Update %s done!\n", event.Novel)
+			}
+		}
+	}
+}
+
+// newNDJSONProgressHandler 每个事件输出一行json
+func newNDJSONProgressHandler(w io.Writer) engine.ProgressHandler {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return func(event engine.ProgressEvent) {
+		encoder.Encode(event)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/twoflyliu/novel/engine"
+	extracter "github.com/twoflyliu/novel/extracter"
+)
+
+// 查看和清理backend在基目录中缓存的页面
+func main() {
+	var olderThan time.Duration
+	flag.DurationVar(&olderThan, "older", 0, "prune: also remove entries stored before the duration, e.g. 720h")
+	configFlags := engine.RegisterConfigFlags(flag.CommandLine, engine.FileConfig{BaseDir: "."})
+	flag.Parse()
+
+	if flag.NArg() != 1 || (flag.Arg(0) != "stats" && flag.Arg(0) != "prune") {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-c config] [-ld dirname] [-sites sites.json] [-older duration] stats|prune\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// 和backend使用同一个sites.json，不单独保存一份
+	cfg, err := configFlags.Config()
+	CheckError(err)
+	mgr := engine.NewEngine(cfg.Options()...)
+	source, err := extracter.RegisterSitesConfig(cfg.SitesConfig, cfg.BaseDir)
+	CheckError(err)
+	mgr.GetLogger().Debugf("Load sites from %s", source)
+	cache := mgr.Cache()
+
+	switch flag.Arg(0) {
+	case "stats":
+		stats, err := cache.Stats()
+		CheckError(err)
+		fmt.Printf("entries: %d\nbytes: %d\nexpired: %d\n", stats.Entries, stats.Bytes, stats.Expired)
+		kinds := make([]string, 0, len(stats.ByKind))
+		for kind := range stats.ByKind {
+			kinds = append(kinds, string(kind))
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Printf("%s: %d\n", kind, stats.ByKind[engine.ResourceKind(kind)])
+		}
+	case "prune":
+		removed, freed, err := cache.Prune(olderThan)
+		CheckError(err)
+		fmt.Printf("removed %d entries, freed %d bytes\n", removed, freed)
+	}
+}
+
+func CheckError(err error) {
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,259 @@
+// Package cleaner 将提取出来的章节内容清理成纯文本
+//
+// 清理过程是一组按顺序执行的步骤(Step)，可以在sites.json中为每个网站配置，
+// 所有类型的Extracter共用
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+// StepType 是清理步骤的类型
+type StepType string
+
+const (
+	StepDecodeEntities StepType = "decode-entities" //解码&ldquo;&nbsp;这样的html实体，不间断空格变成普通的空格
+	StepStripTags      StepType = "strip-tags"      //删除html标签，<br>和块元素变成换行，Drop中的元素连同内容一起删除
+	StepRemove         StepType = "remove"          //删除Pattern匹配的内容
+	StepReplace        StepType = "replace"         //将Pattern匹配的内容替换为Replace，可以使用$1
+	StepBlacklist      StepType = "blacklist"       //删除包含Words中任何一个或者匹配Pattern的行，比如"最新章节请访问..."
+	StepNormalize      StepType = "normalize"       //去掉每一行首尾的空白，删除空行，每一段加上Indent
+)
+
+// Step 是sites.json中的一个清理步骤，不同的Type使用不同的字段
+type Step struct {
+	Type    StepType
+	Pattern string   //remove, replace和blacklist使用的正则表达式
+	Replace string   //replace的替换内容
+	Words   []string //blacklist中的广告词
+	Drop    []string //strip-tags连同内容一起删除的元素，为空的时候是script和style
+	Indent  string   //normalize以后每一段前面的缩进，比如"　　"
+}
+
+// Pipeline 是编译以后的一组清理步骤，可以在多个goroutine中同时使用
+type Pipeline struct {
+	steps []Step
+	funcs []func(string) string
+}
+
+var defaultDrop = []string{"script", "style"}
+
+// DefaultSteps 返回没有配置清理步骤的网站使用的步骤
+func DefaultSteps() []Step {
+	return []Step{{Type: StepStripTags}, {Type: StepDecodeEntities}, {Type: StepNormalize}}
+}
+
+// Default 返回使用DefaultSteps的Pipeline
+func Default() *Pipeline {
+	p, _ := New("Clean", DefaultSteps())
+	return p
+}
+
+// New 编译steps，name用来生成错误信息，比如"BQGExtracter.Clean"
+// 类型未知，正则表达式不合法或者缺少必需的字段的时候返回*engine.ParseError
+func New(name string, steps []Step) (*Pipeline, error) {
+	p := &Pipeline{steps: steps, funcs: make([]func(string) string, 0, len(steps))}
+	for i, step := range steps {
+		f, err := step.compile()
+		if err != nil {
+			err.Field = fmt.Sprintf("%s[%d].%s", name, i, err.Field)
+			return nil, err
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	return p, nil
+}
+
+// Clean 依次使用所有的步骤清理text，p为nil的时候返回text
+func (p *Pipeline) Clean(text string) string {
+	if p == nil {
+		return text
+	}
+	for _, f := range p.funcs {
+		text = f(text)
+	}
+	return text
+}
+
+// Steps 返回编译之前的步骤
+func (p *Pipeline) Steps() []Step {
+	if p == nil {
+		return nil
+	}
+	return p.steps
+}
+
+func (p *Pipeline) String() string {
+	return FormatSteps(p.Steps())
+}
+
+// FormatSteps 返回所有步骤，用来在报告中显示
+func FormatSteps(steps []Step) string {
+	parts := make([]string, 0, len(steps))
+	for _, step := range steps {
+		parts = append(parts, step.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// String 返回步骤的类型和所有不为空的设置
+func (s Step) String() string {
+	parts := []string{string(s.Type)}
+	for _, kv := range [][2]string{
+		{"Pattern", s.Pattern}, {"Replace", s.Replace}, {"Words", strings.Join(s.Words, ",")},
+		{"Drop", strings.Join(s.Drop, ",")}, {"Indent", s.Indent},
+	} {
+		if len(kv[1]) > 0 {
+			parts = append(parts, fmt.Sprintf("%s=`%s`", kv[0], kv[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// compile 返回执行这个步骤的函数，返回的*engine.ParseError中的Field只是字段名称
+func (s Step) compile() (func(string) string, *engine.ParseError) {
+	var pattern *regexp.Regexp
+	if len(s.Pattern) > 0 {
+		var err error
+		if pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return nil, engine.NewParseError("Pattern", s.Pattern, err)
+		}
+	}
+
+	switch s.Type {
+	case StepDecodeEntities:
+		return decodeEntities, nil
+	case StepStripTags:
+		drop := make(map[atom.Atom]bool)
+		names := s.Drop
+		if len(names) == 0 {
+			names = defaultDrop
+		}
+		for _, name := range names {
+			a := atom.Lookup([]byte(strings.ToLower(strings.TrimSpace(name))))
+			if a == 0 {
+				return nil, engine.NewParseError("Drop", name, errors.New("unknown html element"))
+			}
+			drop[a] = true
+		}
+		return func(text string) string { return stripTags(text, drop) }, nil
+	case StepRemove, StepReplace:
+		if pattern == nil {
+			return nil, engine.NewParseError("Pattern", s.Pattern, errors.New("cannot be empty"))
+		}
+		replace := s.Replace
+		if s.Type == StepRemove {
+			replace = ""
+		}
+		return func(text string) string { return pattern.ReplaceAllString(text, replace) }, nil
+	case StepBlacklist:
+		if pattern == nil && len(s.Words) == 0 {
+			return nil, engine.NewParseError("Words", "", errors.New("blacklist needs Words or Pattern"))
+		}
+		words := s.Words
+		return func(text string) string { return removeLines(text, words, pattern) }, nil
+	case StepNormalize:
+		indent := s.Indent
+		return func(text string) string { return normalize(text, indent) }, nil
+	}
+	return nil, engine.NewParseError("Type", string(s.Type), errors.New("unknown clean step"))
+}
+
+// decodeEntities 解码html实体，&nbsp;解码以后的不间断空格换成普通的空格
+func decodeEntities(text string) string {
+	return strings.ReplaceAll(html.UnescapeString(text), "\u00a0", " ")
+}
+
+// stripTags 删除text中的html标签，只保留文本，不解码文本中的html实体
+func stripTags(text string, drop map[atom.Atom]bool) string {
+	var b strings.Builder
+	z := xhtml.NewTokenizer(strings.NewReader(text))
+	dropping := 0 //正在删除的Drop元素的层数
+	for {
+		tt := z.Next()
+		switch tt {
+		case xhtml.ErrorToken:
+			return b.String() //io.EOF，tokenizer不会返回其它的错误
+		case xhtml.TextToken:
+			if dropping == 0 {
+				b.Write(z.Raw())
+			}
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken, xhtml.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if drop[a] {
+				switch {
+				case tt == xhtml.StartTagToken:
+					dropping++
+				case tt == xhtml.EndTagToken && dropping > 0:
+					dropping--
+				}
+				continue
+			}
+			if dropping == 0 && (a == atom.Br || IsBlockElement(a)) {
+				b.WriteByte('\n')
+			}
+		}
+	}
+}
+
+// IsBlockElement 返回a是否是块元素，提取文本的时候块元素的前后需要换行
+func IsBlockElement(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Li, atom.Dd, atom.Dt, atom.Tr, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Blockquote, atom.Pre, atom.Section, atom.Article:
+		return true
+	}
+	return false
+}
+
+// removeLines 删除包含words中任何一个或者匹配pattern的行
+func removeLines(text string, words []string, pattern *regexp.Regexp) string {
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !isBlacklisted(line, words, pattern) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+func isBlacklisted(line string, words []string, pattern *regexp.Regexp) bool {
+	for _, word := range words {
+		if strings.Contains(line, word) {
+			return true
+		}
+	}
+	return pattern != nil && pattern.MatchString(line)
+}
+
+// normalize 每一行是一段，去掉首尾的空白(包括全角空格和不间断空格)，删除空行，然后加上indent
+func normalize(text string, indent string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	paragraphs := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimFunc(line, isSpace)
+		if len(line) > 0 {
+			paragraphs = append(paragraphs, indent+line)
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+func isSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\r', '\u00a0', '\u3000':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package cleaner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+const testContent = `&nbsp;&nbsp;&nbsp;&nbsp;他说：&ldquo;走吧。&rdquo;<br />
+<br />
+&nbsp;&nbsp;&nbsp;&nbsp;A&amp;B&nbsp;&lt;c&gt;<br/>
+　　最新章节请访问www.example.test<br />
+<p>第三段</p><div class="ad"><div>广告</div><script>ad("</div>");</script></div>
+<style>p {}</style>(本章完)`
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []Step
+		expected string
+	}{
+		{"default", DefaultSteps(),
+			"他说：“走吧。”\nA&B <c>\n最新章节请访问www.example.test\n第三段\n广告\n(本章完)"},
+		{"site", []Step{
+			{Type: StepStripTags, Drop: []string{"script", "style", "div"}},
+			{Type: StepDecodeEntities},
+			{Type: StepBlacklist, Words: []string{"最新章节"}, Pattern: `^\s*\(本章完\)\s*$`},
+			{Type: StepReplace, Pattern: `(\w)&(\w)`, Replace: "${1}和${2}"},
+			{Type: StepNormalize, Indent: "　　"},
+		}, "　　他说：“走吧。”\n　　A和B <c>\n　　第三段"},
+		// 先解码以后实体变成了标签，会被删除
+		{"decode first", []Step{{Type: StepDecodeEntities}, {Type: StepStripTags}, {Type: StepRemove, Pattern: `[\s\x{3000}]+`}},
+			"他说：“走吧。”A&B最新章节请访问www.example.test第三段广告(本章完)"},
+		{"empty", nil, testContent},
+	}
+	for _, test := range tests {
+		p, err := New("Clean", test.steps)
+		if err != nil {
+			t.Fatalf("%s: New fail: %v", test.name, err)
+		}
+		if actual := p.Clean(testContent); actual != test.expected {
+			t.Errorf("%s: expected %q, but got %q", test.name, test.expected, actual)
+		}
+	}
+
+	var p *Pipeline
+	if p.Clean("text") != "text" || p.String() != "" {
+		t.Errorf("nil pipeline should not change the text")
+	}
+	if s := Default().String(); s != "strip-tags | decode-entities | normalize" {
+		t.Errorf("unexpected steps %q", s)
+	}
+}
+
+func TestNewPipelineError(t *testing.T) {
+	tests := []struct {
+		steps []Step
+		field string
+	}{
+		{[]Step{{Type: StepNormalize}, {Type: StepRemove, Pattern: "("}}, "X.Clean[1].Pattern"},
+		{[]Step{{Type: StepReplace}}, "X.Clean[0].Pattern"},
+		{[]Step{{Type: StepBlacklist}}, "X.Clean[0].Words"},
+		{[]Step{{Type: StepStripTags, Drop: []string{"advert"}}}, "X.Clean[0].Drop"},
+		{[]Step{{Type: "trim"}}, "X.Clean[0].Type"},
+	}
+	for _, test := range tests {
+		_, err := New("X.Clean", test.steps)
+		var parseErr *engine.ParseError
+		if !errors.As(err, &parseErr) || parseErr.Field != test.field {
+			t.Errorf("expected a parse error of %s, but got %v", test.field, err)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CACHE_DIR_NAME    = "cache" //缓存在baseDirName中的目录
+	CACHE_META_SUFFIX = ".meta"
+	CACHE_BODY_SUFFIX = ".body"
+)
+
+// ResourceKind 表示下载的是什么内容，CachingDownloader根据它决定缓存多长时间
+type ResourceKind string
+
+const (
+	ResourceOther   ResourceKind = "other"
+	ResourceMenu    ResourceKind = "menu"    //目录页，会随着小说的更新而变化
+	ResourceChapter ResourceKind = "chapter" //章节页，下载以后基本不会变化
+	ResourceIcon    ResourceKind = "icon"
+	ResourceSearch  ResourceKind = "search"
+)
+
+// CacheTTLs 是每种内容的缓存时间，小于0表示永不过期，0或者没有配置表示不缓存
+type CacheTTLs map[ResourceKind]time.Duration
+
+// DEFAULT_CACHE_TTLS 章节永不过期，目录页几分钟以后就要重新验证
+var DEFAULT_CACHE_TTLS = CacheTTLs{
+	ResourceChapter: -1,
+	ResourceMenu:    5 * time.Minute,
+	ResourceIcon:    7 * 24 * time.Hour,
+}
+
+type resourceKindKey struct{}
+
+// WithResourceKind 告诉Downloader这次下载的是什么内容
+func WithResourceKind(ctx context.Context, kind ResourceKind) context.Context {
+	return context.WithValue(ctx, resourceKindKey{}, kind)
+}
+
+func resourceKind(ctx context.Context) ResourceKind {
+	if kind, ok := ctx.Value(resourceKindKey{}).(ResourceKind); ok {
+		return kind
+	}
+	return ResourceOther
+}
+
+type revalidateKey struct{}
+
+// WithRevalidate 告诉CachingDownloader这次下载不能直接使用没有过期的缓存，
+// 有ETag或者Last-Modified的时候进行条件请求，否则重新下载
+// 重新下载失败的章节和改名的章节的时候使用，这时缓存中多半是过时的内容
+func WithRevalidate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revalidateKey{}, true)
+}
+
+func mustRevalidate(ctx context.Context) bool {
+	revalidate, _ := ctx.Value(revalidateKey{}).(bool)
+	return revalidate
+}
+
+type requestHeaderKey struct{}
+
+// withRequestHeader 给这次下载增加请求头，比如条件请求的If-None-Match
+func withRequestHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, requestHeaderKey{}, header)
+}
+
+func requestHeader(ctx context.Context) http.Header {
+	header, _ := ctx.Value(requestHeaderKey{}).(http.Header)
+	return header
+}
+
+// cacheMeta 是缓存的元数据，和内容分开保存，这样统计的时候不需要读取内容
+type cacheMeta struct {
+	URL        string //请求的url，也是缓存的键
+	FinalURL   string //重定向以后的url，没有重定向的时候为空
+	Kind       ResourceKind
+	StatusCode int
+	Header     http.Header
+	StoredAt   time.Time //最后一次从网站下载或者验证的时间
+	Size       int64
+}
+
+func (meta *cacheMeta) expired(ttls CacheTTLs, now time.Time) bool {
+	ttl := ttls[meta.Kind]
+	return ttl >= 0 && now.Sub(meta.StoredAt) >= ttl
+}
+
+// CacheStats 是缓存的统计信息
+type CacheStats struct {
+	Entries int
+	Bytes   int64
+	Expired int                  //按照当前的缓存时间已经过期的条目
+	ByKind  map[ResourceKind]int //每种内容的条目数
+
+	Hits        int64 //本进程中直接使用缓存的次数
+	Revalidated int64 //本进程中网站返回304的次数
+	Misses      int64 //本进程中需要重新下载的次数
+}
+
+// CachingDownloader 是Downloader的装饰器，将下载成功的响应保存在dir中
+//
+// 没有过期的缓存直接返回，过期的缓存使用If-None-Match和If-Modified-Since进行条件请求，
+// 网站返回304的时候继续使用缓存。缓存时间由ctx中的ResourceKind决定，
+// ctx使用WithRevalidate的时候没有过期的缓存也要重新验证
+type CachingDownloader struct {
+	downloader Downloader
+	dir        string
+	ttls       CacheTTLs
+
+	hits, revalidated, misses int64
+}
+
+// NewCachingDownloader 创建缓存下载器，downloader为nil的时候只能用来统计和清理缓存
+func NewCachingDownloader(downloader Downloader, dir string, ttls CacheTTLs) *CachingDownloader {
+	if ttls == nil {
+		ttls = DEFAULT_CACHE_TTLS
+	}
+	return &CachingDownloader{downloader: downloader, dir: dir, ttls: ttls}
+}
+
+func (cd *CachingDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	kind := resourceKind(ctx)
+	if ttl := cd.ttls[kind]; ttl == 0 {
+		return cd.downloader.Download(ctx, url, retries)
+	}
+
+	path := cd.path(url)
+	meta, _ := cd.loadMeta(path)
+	if meta != nil && meta.Kind == kind && meta.URL == url {
+		if !meta.expired(cd.ttls, time.Now()) && !mustRevalidate(ctx) {
+			if resp, err := cd.loadResponse(path, meta); err == nil {
+				atomic.AddInt64(&cd.hits, 1)
+				return resp, nil
+			}
+		} else if header := conditionalHeader(meta.Header); len(header) > 0 {
+			resp, err := cd.downloader.Download(withRequestHeader(ctx, header), url, retries)
+			var downloadErr *DownloadError
+			if errors.As(err, &downloadErr) && downloadErr.StatusCode == http.StatusNotModified {
+				if cached, loadErr := cd.loadResponse(path, meta); loadErr == nil {
+					atomic.AddInt64(&cd.revalidated, 1)
+					meta.StoredAt = time.Now()
+					cd.saveMeta(path, meta)
+					return cached, nil
+				}
+				return cd.fetch(ctx, path, url, kind, retries) //缓存的内容丢失了，重新下载
+			}
+			if err != nil {
+				return nil, err
+			}
+			atomic.AddInt64(&cd.misses, 1)
+			cd.store(path, url, kind, resp)
+			return resp, nil
+		}
+	}
+	return cd.fetch(ctx, path, url, kind, retries)
+}
+
+func (cd *CachingDownloader) fetch(ctx context.Context, path string, url string, kind ResourceKind, retries int) (*Response, error) {
+	resp, err := cd.downloader.Download(ctx, url, retries)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&cd.misses, 1)
+	cd.store(path, url, kind, resp)
+	return resp, nil
+}
+
+// conditionalHeader 根据缓存的响应头生成条件请求的请求头
+func conditionalHeader(cached http.Header) http.Header {
+	header := make(http.Header)
+	if etag := cached.Get("ETag"); len(etag) > 0 {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.Get("Last-Modified"); len(lastModified) > 0 {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	return header
+}
+
+// path 返回url对应的缓存文件路径，不包括后缀
+func (cd *CachingDownloader) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(cd.dir, name[:2], name)
+}
+
+func (cd *CachingDownloader) loadMeta(path string) (*cacheMeta, error) {
+	data, err := os.ReadFile(path + CACHE_META_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	meta := new(cacheMeta)
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (cd *CachingDownloader) loadResponse(path string, meta *cacheMeta) (*Response, error) {
+	body, err := os.ReadFile(path + CACHE_BODY_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	finalURL := meta.URL
+	if len(meta.FinalURL) > 0 {
+		finalURL = meta.FinalURL
+	}
+	return &Response{URL: finalURL, StatusCode: meta.StatusCode, Header: meta.Header.Clone(), Body: body}, nil
+}
+
+// store 以请求的url保存响应，保存失败只记录日志，不影响这次下载
+// 重定向的时候resp.URL是最终的url，另外记录在FinalURL中
+func (cd *CachingDownloader) store(path string, url string, kind ResourceKind, resp *Response) {
+	if err := makeDirIfNotExist(filepath.Dir(path)); err != nil {
+		log.Errorf("Create cache dir fail: %v", err)
+		return
+	}
+	if err := writeFileAtomic(path+CACHE_BODY_SUFFIX, resp.Body); err != nil {
+		log.Errorf("Save cache of %q fail: %v", url, err)
+		return
+	}
+	meta := &cacheMeta{URL: url, Kind: kind, StatusCode: resp.StatusCode, Header: resp.Header,
+		StoredAt: time.Now(), Size: int64(len(resp.Body))}
+	if resp.URL != url {
+		meta.FinalURL = resp.URL
+	}
+	cd.saveMeta(path, meta)
+}
+
+func (cd *CachingDownloader) saveMeta(path string, meta *cacheMeta) {
+	data, err := json.Marshal(meta)
+	if err == nil {
+		err = writeFileAtomic(path+CACHE_META_SUFFIX, data)
+	}
+	if err != nil {
+		log.Errorf("Save cache of %q fail: %v", meta.URL, err)
+	}
+}
+
+// writeFileAtomic 先写到临时文件再重命名，并发下载同一个url的时候也不会读到写了一半的文件
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// walk 遍历所有缓存条目，不能解析的元数据文件meta为nil
+func (cd *CachingDownloader) walk(fn func(path string, meta *cacheMeta) error) error {
+	err := filepath.Walk(cd.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(file, CACHE_META_SUFFIX) {
+			return nil
+		}
+		path := strings.TrimSuffix(file, CACHE_META_SUFFIX)
+		meta, _ := cd.loadMeta(path)
+		return fn(path, meta)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Stats 统计缓存目录中的条目
+func (cd *CachingDownloader) Stats() (stats CacheStats, err error) {
+	stats.ByKind = make(map[ResourceKind]int)
+	stats.Hits = atomic.LoadInt64(&cd.hits)
+	stats.Revalidated = atomic.LoadInt64(&cd.revalidated)
+	stats.Misses = atomic.LoadInt64(&cd.misses)
+
+	now := time.Now()
+	err = cd.walk(func(path string, meta *cacheMeta) error {
+		if meta == nil {
+			return nil
+		}
+		stats.Entries++
+		stats.Bytes += meta.Size
+		stats.ByKind[meta.Kind]++
+		if meta.expired(cd.ttls, now) {
+			stats.Expired++
+		}
+		return nil
+	})
+	return
+}
+
+// Prune 删除已经过期的条目，以及olderThan之前保存的条目，olderThan <= 0的时候只删除过期的条目
+func (cd *CachingDownloader) Prune(olderThan time.Duration) (removed int, freed int64, err error) {
+	now := time.Now()
+	err = cd.walk(func(path string, meta *cacheMeta) error {
+		if meta != nil && !meta.expired(cd.ttls, now) && (olderThan <= 0 || now.Sub(meta.StoredAt) < olderThan) {
+			return nil
+		}
+		if meta != nil {
+			freed += meta.Size
+		}
+		removed++
+		os.Remove(path + CACHE_BODY_SUFFIX)
+		return os.Remove(path + CACHE_META_SUFFIX)
+	})
+	return
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingDownloader(t *testing.T) {
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	ttls := CacheTTLs{ResourceChapter: -1, ResourceMenu: time.Nanosecond}
+	downloader := NewCachingDownloader(NewTimeoutDownloader(time.Second), dir, ttls)
+	chapterCtx := WithResourceKind(context.Background(), ResourceChapter)
+	menuCtx := WithResourceKind(context.Background(), ResourceMenu)
+
+	// 章节永不过期，第二次不需要请求
+	for i := 0; i < 2; i++ {
+		if content, err := DownloadText(chapterCtx, downloader, server.URL+"/1.html", 0); err != nil || content != "page /1.html" {
+			t.Fatalf("unexpected chapter %q, %v", content, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request for immutable chapter, but got %d", requests)
+	}
+
+	// 目录页过期以后使用条件请求
+	for i := 0; i < 2; i++ {
+		if content, err := DownloadText(menuCtx, downloader, server.URL+"/", 0); err != nil || content != "page /" {
+			t.Fatalf("unexpected menu %q, %v", content, err)
+		}
+	}
+	if requests != 3 || notModified != 1 {
+		t.Errorf("expected a conditional request for menu, but got %d requests, %d not modified", requests, notModified)
+	}
+
+	// 没有配置缓存时间的内容不缓存
+	DownloadText(context.Background(), downloader, server.URL+"/search", 0)
+	DownloadText(context.Background(), downloader, server.URL+"/search", 0)
+	if requests != 5 {
+		t.Errorf("expected 5 requests, but got %d", requests)
+	}
+
+	stats, err := downloader.Stats()
+	if err != nil || stats.Entries != 2 || stats.ByKind[ResourceChapter] != 1 || stats.Expired != 1 ||
+		stats.Hits != 1 || stats.Revalidated != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats %+v, %v", stats, err)
+	}
+	if removed, _, err := downloader.Prune(0); err != nil || removed != 1 {
+		t.Errorf("expected the expired menu to be pruned, but removed %d, %v", removed, err)
+	}
+	if removed, _, _ := downloader.Prune(time.Nanosecond); removed != 1 {
+		t.Errorf("expected the chapter to be pruned, but removed %d", removed)
+	}
+}
+
+// 重定向的页面以请求的url缓存，返回的仍然是最终的url
+func TestCachingDownloaderRedirect(t *testing.T) {
+	var requests, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/new/" {
+			http.Redirect(w, r, "/new/", http.StatusMovedPermanently)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	ttls := CacheTTLs{ResourceChapter: -1, ResourceMenu: time.Nanosecond}
+	downloader := NewCachingDownloader(NewTimeoutDownloader(time.Second), t.TempDir(), ttls)
+	chapterCtx := WithResourceKind(context.Background(), ResourceChapter)
+	menuCtx := WithResourceKind(context.Background(), ResourceMenu)
+
+	// 章节第二次直接使用缓存
+	for i := 0; i < 2; i++ {
+		resp, err := downloader.Download(chapterCtx, server.URL+"/old.html", 0)
+		if err != nil || resp.URL != server.URL+"/new/" || string(resp.Body) != "page /new/" {
+			t.Fatalf("unexpected response %+v, %v", resp, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected the redirected chapter to be cached, but got %d requests", requests)
+	}
+
+	// 过期的目录页使用条件请求
+	for i := 0; i < 2; i++ {
+		resp, err := downloader.Download(menuCtx, server.URL+"/old/", 0)
+		if err != nil || resp.URL != server.URL+"/new/" || string(resp.Body) != "page /new/" {
+			t.Fatalf("unexpected response %+v, %v", resp, err)
+		}
+	}
+	if notModified != 1 {
+		t.Errorf("expected a conditional request for the redirected menu, but got %d not modified", notModified)
+	}
+}
+
+// 使用默认的下载器，修复和更新的时候不能使用缓存中的空页面和请假条
+func TestCacheRepairAndSync(t *testing.T) {
+	RegisterExtracter(`cache\.test`, &lineExtracter{})
+	menuURL := "http://cache.test/book/"
+	var mu sync.Mutex
+	pages := map[string]string{
+		"/book/":       "0.html|chapter 0\n1.html|chapter 1\n2.html|请假条",
+		"/book/0.html": "chapter 0\ncontent 0",
+		"/book/1.html": "chapter 1\n", //内容为空
+		"/book/2.html": "请假条\n今天请假",
+	}
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, sha1.Sum([]byte(page)))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(page))
+	}))
+	defer server.Close()
+	setPage := func(path string, page string) {
+		mu.Lock()
+		defer mu.Unlock()
+		pages[path] = page
+	}
+
+	// 网站通过代理访问，目录页马上过期，章节永不过期
+	engine := NewEngine(WithLogger(log), WithMaxRetries(0), WithBaseDir(t.TempDir()), WithNovelDir(t.TempDir(), ""),
+		WithDownloaderOptions(WithProxy(server.URL)),
+		WithCacheTTLs(CacheTTLs{ResourceChapter: DEFAULT_CACHE_TTLS[ResourceChapter], ResourceMenu: time.Nanosecond}))
+	novel, err := engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+
+	setPage("/book/1.html", "chapter 1\ncontent 1")
+	if repaired, err := engine.RepairNovel(context.Background(), novel); err != nil || repaired != 1 {
+		t.Errorf("expected 1 repaired chapter, but got %d, %v", repaired, err)
+	}
+	if novel.Chapters[1].Content != "content 1" {
+		t.Errorf("chapter 1 is not repaired: %+v", novel.Chapters[1])
+	}
+
+	// 请假条被替换成同一个url上的真正的章节
+	setPage("/book/", "0.html|chapter 0\n1.html|chapter 1\n2.html|chapter 2")
+	setPage("/book/2.html", "chapter 2\ncontent 2")
+	if _, err := engine.SyncNovel(context.Background(), novel); err != nil {
+		t.Fatalf("SyncNovel fail: %v", err)
+	}
+	if chapter := novel.Chapters[2]; chapter.Title != "chapter 2" || chapter.Content != "content 2" {
+		t.Errorf("the placeholder is not replaced: %+v", chapter)
+	}
+
+	// 没有变化的章节仍然使用缓存
+	if requests["/book/0.html"] != 1 {
+		t.Errorf("chapter 0 requested %d times", requests["/book/0.html"])
+	}
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/twoflyliu/novel/tool"
+)
+
+const (
+	charsetSniffLen = 16 * 1024 //只在页面的前面这么多字节中查找meta和猜测编码
+)
+
+// sniffCandidate 是猜测编码时候的候选编码，common中的字符出现得越多，越可能是这个编码
+type sniffCandidate struct {
+	charset string
+	common  string
+}
+
+var sniffCandidates = []sniffCandidate{
+	{"gb18030", "的一是不了人我在有他这中大来上个到说们为子和你地出道也时年得就那要下以生会自着去之过家学对可她里后小么心多天而能好都然没日于起还发成事只作当想看文无开手十用主行方又如前所本见经头面"},
+	{"big5", "的一是不了人我在有他這中大來上個到說們為子和你地出道也時年得就那要下以生會自著去之過家學對可她裡後小麼心多天而能好都然沒日於起還發成事只作當想看文無開手十用主行方又如前所本見經頭面"},
+	{"shift_jis", "あいうえおかきくけこさしすせそたちつてとなにぬねのはひふへほまみむめもやゆよらりるれろわをんがぎぐげござじずぜぞだでどばびぶべぼアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワヲン"},
+	{"euc-jp", "あいうえおかきくけこさしすせそたちつてとなにぬねのはひふへほまみむめもやゆよらりるれろわをんがぎぐげござじずぜぞだでどばびぶべぼアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワヲン"},
+}
+
+// DetectCharset 按照下面的顺序确定页面的编码，返回小写的编码名称
+//
+// 1. BOM
+// 2. http响应头Content-Type中的charset
+// 3. 页面中的<meta charset=...>或者<meta http-equiv="Content-Type" content="...; charset=...">
+// 4. 根据内容进行猜测，合法的utf-8优先，否则在中文和日文的常见编码中选择最像的一个
+func DetectCharset(contentType string, body []byte) string {
+	switch {
+	case bytes.HasPrefix(body, []byte("\xef\xbb\xbf")):
+		return "utf-8"
+	case bytes.HasPrefix(body, []byte("\xfe\xff")):
+		return "utf-16be"
+	case bytes.HasPrefix(body, []byte("\xff\xfe")):
+		return "utf-16le"
+	}
+
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if charset := normalizeCharset(params["charset"]); len(charset) > 0 {
+			return charset
+		}
+	}
+
+	head := body
+	if len(head) > charsetSniffLen {
+		head = head[:charsetSniffLen]
+	}
+	if charset := normalizeCharset(ExtractCharset(string(head))); len(charset) > 0 {
+		return charset
+	}
+	return sniffCharset(head)
+}
+
+// normalizeCharset 返回小写的编码名称，不支持的编码返回空字符串
+func normalizeCharset(charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if len(charset) == 0 || !tool.IsSupportedCharset(charset) {
+		return ""
+	}
+	return charset
+}
+
+// sniffCharset 根据内容猜测编码
+func sniffCharset(head []byte) string {
+	if isValidUTF8Prefix(head) {
+		return "utf-8"
+	}
+
+	best, bestScore := "gb18030", -1<<31
+	for _, candidate := range sniffCandidates {
+		text, err := tool.DecodeToUTF8(head, candidate.charset)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range text {
+			if r == utf8.RuneError {
+				score -= 10
+			} else if r > utf8.RuneSelf && strings.ContainsRune(candidate.common, r) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = candidate.charset, score
+		}
+	}
+	return best
+}
+
+// isValidUTF8Prefix 和utf8.Valid一样，但是允许结尾有一个被截断的字符
+func isValidUTF8Prefix(data []byte) bool {
+	for i := 0; i < utf8.UTFMax && i < len(data); i++ {
+		if utf8.Valid(data[:len(data)-i]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestDetectCharset(t *testing.T) {
+	text := "第一章 少年离开了家乡，他说我们要去很远的地方。"
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(text)
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("第一章 少年離開了家鄉，他說我們要去很遠的地方。")
+	sjis, _ := japanese.ShiftJIS.NewEncoder().String("第一章 少年はふるさとを離れて、遠いところへ行きました。")
+
+	datas := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"text/html; charset=GBK", gbk, "gbk"},
+		{"text/html", "\xef\xbb\xbf" + text, "utf-8"},
+		{"", `<html><head><meta http-equiv="Content-Type" content="text/html; charset=gb2312" /></head>`, "gb2312"},
+		{"", `<html><head><meta charset='Big5'></head>`, "big5"},
+		{"text/html; charset=unknown", "<html>" + text, "utf-8"},
+		{"", gbk + gbk, "gb18030"},
+		{"", big5 + big5, "big5"},
+		{"", sjis + sjis, "shift_jis"},
+	}
+	for _, data := range datas {
+		if charset := DetectCharset(data.contentType, []byte(data.body)); charset != data.expected {
+			t.Errorf("DetectCharset(%q, %q): expected [%s], but got [%s]", data.contentType, data.body, data.expected, charset)
+		}
+	}
+
+	// Text按照检测到的编码进行转换
+	resp := &Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/html; charset=big5"}}, Body: []byte(big5)}
+	if page, err := resp.Text(); err != nil || page != "第一章 少年離開了家鄉，他說我們要去很遠的地方。" {
+		t.Errorf("Text: unexpected %q, %v", page, err)
+	}
+}
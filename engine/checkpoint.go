@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+const (
+	CHECKPOINT_DIR_NAME = "checkpoints" //检查点文件在baseDirName中的目录
+	CHECKPOINT_SUFFIX   = ".ckpt"
+)
+
+// checkpointRecord 是检查点文件中的一行
+type checkpointRecord struct {
+	URL     string   //章节的url
+	Chapter *Chapter //已经下载完毕的章节
+}
+
+// checkpoint 用来在下载过程中记录已经下载完毕的章节
+// 每下载完毕一个章节就往文件中追加一行json，所以进程中途退出的话，已经下载的章节也不会丢失
+// 同一本小说（MenuURL相同）使用同一个检查点文件，小说保存成功以后会删除检查点文件
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func checkpointPath(cfg *Config, menuURL string) string {
+	sum := sha1.Sum([]byte(menuURL))
+	return cfg.Path(CHECKPOINT_DIR_NAME, hex.EncodeToString(sum[:])+CHECKPOINT_SUFFIX)
+}
+
+// openCheckpoint 打开menuURL对应的检查点文件，不存在则创建, 检查点文件保存在cfg的基目录中
+func openCheckpoint(cfg *Config, menuURL string) (ckpt *checkpoint, err error) {
+	err = makeDirIfNotExist(cfg.Path(CHECKPOINT_DIR_NAME))
+	if err != nil {
+		return
+	}
+	path := checkpointPath(cfg, menuURL)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	return &checkpoint{path: path, file: file}, nil
+}
+
+// load 返回检查点中所有已经下载完毕的章节，key是章节的url
+// 最后一行可能因为进程被杀死而不完整，不能解析的行会被忽略
+func (ckpt *checkpoint) load() (chapters map[string]*Chapter, err error) {
+	ckpt.mu.Lock()
+	defer ckpt.mu.Unlock()
+
+	chapters = make(map[string]*Chapter)
+	if _, err = ckpt.file.Seek(0, 0); err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(ckpt.file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) //一个章节可能很大
+	for scanner.Scan() {
+		var record checkpointRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Chapter == nil {
+			log.Debugf("Ignore corrupt line in checkpoint %q", ckpt.path)
+			continue
+		}
+		chapters[record.URL] = record.Chapter
+	}
+	err = scanner.Err()
+	return
+}
+
+// save 将下载完毕的章节追加到检查点中，可以在多个goroutine中同时调用
+func (ckpt *checkpoint) save(url string, chapter *Chapter) error {
+	bytes, err := json.Marshal(&checkpointRecord{url, chapter})
+	if err != nil {
+		return err
+	}
+	ckpt.mu.Lock()
+	defer ckpt.mu.Unlock()
+	_, err = ckpt.file.Write(append(bytes, '\n'))
+	return err
+}
+
+func (ckpt *checkpoint) close() error {
+	return ckpt.file.Close()
+}
+
+// removeCheckpoint 删除menuURL对应的检查点文件
+func removeCheckpoint(cfg *Config, menuURL string) error {
+	err := os.Remove(checkpointPath(cfg, menuURL))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newTestNovelSite 返回一个有count个章节的小说站点的页面，菜单页面为menuURL
+func newTestNovelSite(menuURL string, count int) map[string]string {
+	pages := make(map[string]string)
+	menu := make([]string, 0)
+	for i := 0; i < count; i++ {
+		page := fmt.Sprintf("%d.html", i)
+		menu = append(menu, fmt.Sprintf("%s|chapter %d", page, i))
+		pages[menuURL+page] = fmt.Sprintf("chapter %d\ncontent %d", i, i)
+	}
+	pages[menuURL] = strings.Join(menu, "\n")
+	return pages
+}
+
+func TestNovelByURLResumeFromCheckpoint(t *testing.T) {
+	baseDir := t.TempDir()
+	RegisterExtracter(`novel\.test`, &lineExtracter{})
+
+	menuURL := "http://novel.test/book/1/"
+	pages := newTestNovelSite(menuURL, 20)
+
+	// 第一次下载的时候，后面一半的章节下载失败
+	broken := make(map[string]string)
+	for u, page := range pages {
+		broken[u] = page
+	}
+	for i := 10; i < 20; i++ {
+		delete(broken, fmt.Sprintf("%s%d.html", menuURL, i))
+	}
+	engine := newTestEngine(newMapDownloader(broken), WithBaseDir(baseDir))
+	novel, err := engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if indexes := novel.IncompleteChapterIndexes(); len(indexes) != 10 || indexes[0] != 10 {
+		t.Fatalf("expected chapters [10, 20) incomplete, but got %v", indexes)
+	}
+
+	// 第二次下载只下载缺少的章节
+	downloader := newMapDownloader(pages)
+	engine = newTestEngine(downloader, WithBaseDir(baseDir))
+	novel, err = engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if indexes := novel.IncompleteChapterIndexes(); len(indexes) != 0 {
+		t.Fatalf("expected all chapters complete, but got incomplete %v", indexes)
+	}
+	for i := 0; i < 20; i++ {
+		u := fmt.Sprintf("%s%d.html", menuURL, i)
+		expected := 0
+		if i >= 10 {
+			expected = 1
+		}
+		if downloader.count[u] != expected {
+			t.Errorf("%s downloaded %d times, expected %d", u, downloader.count[u], expected)
+		}
+		if novel.Chapters[i].Title != fmt.Sprintf("chapter %d", i) {
+			t.Errorf("unexpected title of chapter %d: %q", i, novel.Chapters[i].Title)
+		}
+	}
+}
@@ -2,15 +2,19 @@ package engine
 
 import (
 	"os"
+	"path/filepath"
 )
 
-// 所有和配置有关的信息都要通过config来进行操作
+// 所有和配置有关的信息都要通过config来进行操作, 每个Engine都有自己的Config
 type Config struct {
 	baseDirName         string //所有日志相关文件所在的目录
 	ignoredHostFileName string //他是Searcher中要用到的忽略掉的主机源 文件名称
+	cookieFileName      string //默认下载器保存cookie的文件名称
 }
 
-var config = &Config{baseDirName: "./", ignoredHostFileName: ".ignored_host_file"}
+func newConfig() *Config {
+	return &Config{baseDirName: "./", ignoredHostFileName: ".ignored_host_file", cookieFileName: COOKIE_FILE_NAME}
+}
 
 // 设置基目录，并且如果不存在会进行创建，但是如果因为你制定的目录需要超级权限，那么很可能会创建失败
 // 所以有个error返回值
@@ -35,3 +39,20 @@ func (cfg *Config) SetIgnoredHostFileName(ignoredHostFileName string) {
 func (cfg *Config) IgnoredHostFileName() string {
 	return cfg.ignoredHostFileName
 }
+
+func (cfg *Config) SetCookieFileName(cookieFileName string) {
+	cfg.cookieFileName = cookieFileName
+}
+
+func (cfg *Config) CookieFileName() string {
+	return cfg.cookieFileName
+}
+
+// 返回baseDirName下面的路径，baseDirName为空的时候表示当前目录
+func (cfg *Config) Path(elem ...string) string {
+	baseDirName := cfg.baseDirName
+	if len(baseDirName) == 0 {
+		baseDirName = "."
+	}
+	return filepath.Join(append([]string{baseDirName}, elem...)...)
+}
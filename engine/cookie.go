@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	COOKIE_FILE_NAME = ".cookies.json" //保存在基目录下面的cookie文件
+)
+
+// PersistentCookieJar 是保存在本地文件中的http.CookieJar，进程重新启动以后网站的会话仍然有效
+//
+// 文件中按照设置cookie的网站保存cookie，加载的时候重新设置到cookiejar.Jar中，过期的cookie会被丢弃
+type PersistentCookieJar struct {
+	jar  *cookiejar.Jar
+	path string
+
+	mu      sync.Mutex
+	entries map[string]map[string]*http.Cookie //origin(scheme://host) -> cookie name -> cookie
+}
+
+// NewPersistentCookieJar 从path加载cookie，文件不存在的时候创建一个空的jar
+func NewPersistentCookieJar(path string) (*PersistentCookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	pj := &PersistentCookieJar{jar: jar, path: path, entries: make(map[string]map[string]*http.Cookie)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pj, nil
+	}
+	if err != nil {
+		return pj, err
+	}
+	var entries map[string][]*http.Cookie
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return pj, NewParseError("CookieFile", path, err)
+	}
+
+	now := time.Now()
+	for origin, cookies := range entries {
+		u, err := url.Parse(origin)
+		if err != nil {
+			continue
+		}
+		valid := make([]*http.Cookie, 0, len(cookies))
+		for _, cookie := range cookies {
+			if cookie.Expires.IsZero() || cookie.Expires.After(now) {
+				valid = append(valid, cookie)
+			}
+		}
+		pj.jar.SetCookies(u, valid)
+		pj.record(origin, valid)
+	}
+	return pj, nil
+}
+
+func (pj *PersistentCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return pj.jar.Cookies(u)
+}
+
+// SetCookies 设置cookie，并且立即保存到本地文件
+func (pj *PersistentCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	pj.jar.SetCookies(u, cookies)
+
+	pj.mu.Lock()
+	defer pj.mu.Unlock()
+	pj.recordLocked(u.Scheme+"://"+u.Host, cookies)
+	if err := pj.saveLocked(); err != nil {
+		log.Errorf("Save cookies to %q fail: %v", pj.path, err)
+	}
+}
+
+func (pj *PersistentCookieJar) record(origin string, cookies []*http.Cookie) {
+	pj.mu.Lock()
+	defer pj.mu.Unlock()
+	pj.recordLocked(origin, cookies)
+}
+
+func (pj *PersistentCookieJar) recordLocked(origin string, cookies []*http.Cookie) {
+	entry, ok := pj.entries[origin]
+	if !ok {
+		entry = make(map[string]*http.Cookie)
+		pj.entries[origin] = entry
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())) {
+			delete(entry, cookie.Name) //网站要求删除这个cookie
+			continue
+		}
+		if cookie.MaxAge > 0 && cookie.Expires.IsZero() {
+			saved := *cookie
+			saved.Expires = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+			saved.MaxAge = 0
+			cookie = &saved
+		}
+		entry[cookie.Name] = cookie
+	}
+}
+
+func (pj *PersistentCookieJar) saveLocked() error {
+	entries := make(map[string][]*http.Cookie, len(pj.entries))
+	for origin, entry := range pj.entries {
+		for _, cookie := range entry {
+			entries[origin] = append(entries[origin], cookie)
+		}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写到临时文件再重命名，避免写到一半的时候进程被结束
+	tmp, err := os.CreateTemp(filepath.Dir(pj.path), filepath.Base(pj.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pj.path)
+}
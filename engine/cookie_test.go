@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionDownloader(t *testing.T) {
+	var mu sync.Mutex
+	var userAgents, referers, sessions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		userAgents = append(userAgents, r.UserAgent())
+		referers = append(referers, r.Referer())
+		if cookie, err := r.Cookie("session"); err == nil {
+			sessions = append(sessions, cookie.Value)
+		} else {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "42", Path: "/", MaxAge: 3600})
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	RegisterSiteSetting("^"+host+"$", SiteSetting{Headers: map[string]string{"Referer": "{scheme}://{host}/"}})
+
+	path := filepath.Join(t.TempDir(), COOKIE_FILE_NAME)
+	for i := 0; i < 2; i++ {
+		// 每次都重新加载cookie文件，模拟重新启动进程
+		jar, err := NewPersistentCookieJar(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		downloader := NewTimeoutDownloader(time.Second)
+		downloader.SetCookieJar(jar)
+		downloader.SetUserAgents([]string{"ua-1", "ua-2"})
+		for j := 0; j < 2; j++ {
+			if _, err := downloader.Download(context.Background(), server.URL+"/book/", 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(sessions) != 3 || sessions[0] != "42" {
+		t.Errorf("expected session cookie to be sent 3 times, but got %v", sessions)
+	}
+	if userAgents[0] != "ua-1" || userAgents[1] != "ua-2" {
+		t.Errorf("expected user agents to rotate, but got %v", userAgents)
+	}
+	if referers[0] != server.URL+"/" {
+		t.Errorf("expected referer %s/, but got %v", server.URL, referers)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
@@ -70,7 +72,7 @@ func (dao *JsonNovelDao) SaveNovel(novel *Novel, dirname string, suffix string)
 	}
 	fullpath := fmt.Sprintf("%s/%s%s", dirname, novel.Name, suffix)
 	log.Infof("Save nove to native %q", fullpath)
-	file, err := os.OpenFile(fullpath, os.O_WRONLY|os.O_CREATE, 0666)
+	file, err := os.OpenFile(fullpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		log.Debugf("Save novel to native fail, err:%v", err)
 		return
@@ -89,7 +91,7 @@ func (dao *JsonNovelDao) SaveNovel(novel *Novel, dirname string, suffix string)
 func (dao *JsonNovelDao) LoadNovel(fullpath string) (novel *Novel, err error) {
 	file, err := os.Open(fullpath)
 	if err != nil {
-		err = NewNovelNotExistError(novel.Name)
+		err = NewNovelNotExistError(strings.TrimSuffix(filepath.Base(fullpath), filepath.Ext(fullpath)))
 		return
 	}
 	defer file.Close()
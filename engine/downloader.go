@@ -1,111 +1,279 @@
 package engine
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/twoflyliu/novel/tool"
 )
 
+// Downloader 负责从互联网上下载页面
+// 非2xx的响应是*DownloadError，ctx被取消或者超时的时候，Download应该尽快返回*CancelledError
 type Downloader interface {
-	Download(url string, retries int) (string, error)
+	Download(ctx context.Context, url string, retries int) (*Response, error)
 }
 
+// Response 是一次成功下载的结果，Body是没有经过任何转换的原始字节
+type Response struct {
+	URL        string //跟随重定向以后最终的URL
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Text 将Body按照页面的编码转换成utf-8的字符串，图片等二进制内容应该直接使用Body
+// 编码由DetectCharset确定
+func (resp *Response) Text() (string, error) {
+	charset := DetectCharset(resp.Header.Get("Content-Type"), resp.Body)
+	fullPage, err := tool.DecodeToUTF8(resp.Body, charset)
+	if err != nil {
+		return "", NewDownloadError(resp.URL, resp.StatusCode,
+			fmt.Errorf("ConvertToUTF8 fail: %v, origin charset: %s", err, charset))
+	}
+	return fullPage, nil
+}
+
+// DownloadText 使用downloader下载url，并且将内容转换成utf-8的字符串
+func DownloadText(ctx context.Context, downloader Downloader, url string, retries int) (string, error) {
+	resp, err := downloader.Download(ctx, url, retries)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text()
+}
+
+const (
+	DEFAULT_MAX_IDLE_CONNS          = 100
+	DEFAULT_MAX_IDLE_CONNS_PER_HOST = 2 * THREAD_COUNT //每个worker都可以复用一个连接
+
+	DEFAULT_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.80 Safari/537.36"
+)
+
 type TimeoutDownloader struct {
-	timeout time.Duration
+	timeout            time.Duration
+	retryPolicy        RetryPolicy
+	jar                http.CookieJar //为nil的时候不保存cookie
+	userAgents         []string       //轮流使用的User-Agent
+	uaIndex            uint32
+	proxy              string //为空的时候使用环境变量中的代理
+	insecureSkipVerify bool   //是否对所有网站都不验证证书
+
+	mu      sync.Mutex
+	clients map[clientKey]*http.Client //长期使用的client，同一个主机的连接可以复用
 }
 
-func NewTimeoutDownloader(timeout time.Duration) *TimeoutDownloader {
-	return &TimeoutDownloader{timeout: timeout}
+// clientKey 区分不同代理和证书验证设置的client，它们不能共用连接池
+type clientKey struct {
+	proxy              string
+	insecureSkipVerify bool
 }
 
-func (downloader *TimeoutDownloader) Download(url string, maxRetries int) (result string, err error) {
-	return downloader.doDownloadAndRetryIfFail(url, maxRetries)
+// DownloaderOption 用来配置NewTimeoutDownloader创建的下载器
+type DownloaderOption func(downloader *TimeoutDownloader)
+
+// WithProxy 设置代理，比如http://127.0.0.1:8080, socks5://127.0.0.1:1080
+// 为空的时候使用HTTP_PROXY, HTTPS_PROXY, NO_PROXY环境变量，PROXY_DIRECT表示不使用代理
+// sites.json中给网站单独配置的代理优先
+func WithProxy(proxy string) DownloaderOption {
+	return func(downloader *TimeoutDownloader) {
+		downloader.proxy = proxy
+	}
 }
 
-// maxRetries 表示下载失败， 重新尝试的次数
-// 如果maxRetries = 0，那么就下载一次，如果等于1，那么如果下载失败，就会重新再下载一次
-func (downloader *TimeoutDownloader) doDownloadAndRetryIfFail(url string, maxRetries int) (content string, err error) {
-	// always download util success
-	if maxRetries < 0 {
-		for {
-			content, err = downloader.doDownload(url)
-			if err == nil {
-				log.Debugf("Must downloader: url: [%v], err: [%v]", url, err)
-				return
-			} else {
-				err = fmt.Errorf("ErrorType: %T, Error: %v", err, err)
-				log.Debugf("Must downloader: url: [%v], err: [%v]", url, err)
-			}
-		}
+// WithInsecureSkipVerify 设置是否对所有网站都不验证https证书，默认验证
+// 只需要对个别网站关闭验证的时候，应该在sites.json中给这个网站单独设置InsecureSkipVerify
+func WithInsecureSkipVerify(insecureSkipVerify bool) DownloaderOption {
+	return func(downloader *TimeoutDownloader) {
+		downloader.insecureSkipVerify = insecureSkipVerify
 	}
+}
 
-	// do retry download util maxRetries
-	for maxRetries >= 0 {
-		log.Debugf("Retry downloader: retry: [%v], url: [%v], err: [%v]", maxRetries, url, err)
-		content, err = downloader.doDownload(url)
-		if err == nil {
-			break
-		}
-		maxRetries--
+func NewTimeoutDownloader(timeout time.Duration, opts ...DownloaderOption) *TimeoutDownloader {
+	downloader := &TimeoutDownloader{timeout: timeout, retryPolicy: DEFAULT_RETRY_POLICY,
+		userAgents: []string{DEFAULT_USER_AGENT}, clients: make(map[clientKey]*http.Client)}
+	for _, opt := range opts {
+		opt(downloader)
 	}
-	return
+	return downloader
 }
 
-func (downloader *TimeoutDownloader) doDownload(url string) (page string, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			var ok bool
-			if err, ok = e.(error); !ok {
-				err = fmt.Errorf("Error:%v", e)
-			}
-		}
-	}()
+// 设置下载失败以后的重试策略
+func (downloader *TimeoutDownloader) SetRetryPolicy(policy RetryPolicy) {
+	downloader.retryPolicy = policy
+}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// 设置保存网站会话的cookie jar，比如PersistentCookieJar
+func (downloader *TimeoutDownloader) SetCookieJar(jar http.CookieJar) {
+	downloader.mu.Lock()
+	defer downloader.mu.Unlock()
+	downloader.jar = jar
+	for _, client := range downloader.clients {
+		client.Jar = jar
 	}
+}
 
-	client := &http.Client{
-		Timeout:   downloader.timeout,
-		Transport: tr,
-	} //设置超时时间
-
-	request, _ := http.NewRequest(http.MethodGet, url, nil)
-	request.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.80 Safari/537.36")
+// CloseIdleConnections 关闭连接池中空闲的连接
+func (downloader *TimeoutDownloader) CloseIdleConnections() {
+	downloader.mu.Lock()
+	defer downloader.mu.Unlock()
+	for _, client := range downloader.clients {
+		client.CloseIdleConnections()
+	}
+}
 
-	resp, err := client.Do(request)
-	CheckError(err)
-	defer resp.Body.Close()
+// 设置轮流使用的User-Agent，sites.json中给网站单独配置的User-Agent优先
+func (downloader *TimeoutDownloader) SetUserAgents(userAgents []string) {
+	if len(userAgents) == 0 {
+		userAgents = []string{DEFAULT_USER_AGENT}
+	}
+	downloader.userAgents = userAgents
+}
 
-	bytes, err := ioutil.ReadAll(resp.Body)
-	CheckError(err)
+// nextUserAgent 从userAgents中轮流选择一个
+func (downloader *TimeoutDownloader) nextUserAgent(userAgents []string) string {
+	index := atomic.AddUint32(&downloader.uaIndex, 1) - 1
+	return userAgents[int(index%uint32(len(userAgents)))]
+}
 
-	page, err = downloader.convertToUTF8(bytes)
-	CheckError(err)
-	return
+// setHeaders 设置User-Agent，sites.json中给网站配置的请求头和ctx中的请求头(比如条件请求)
+func (downloader *TimeoutDownloader) setHeaders(request *http.Request, setting SiteSetting) {
+	userAgents := setting.UserAgents
+	if len(userAgents) == 0 {
+		userAgents = downloader.userAgents
+	}
+	request.Header.Set("User-Agent", downloader.nextUserAgent(userAgents))
+	for key, value := range setting.Headers {
+		request.Header.Set(key, expandHeader(value, request.URL))
+	}
+	for key, values := range requestHeader(request.Context()) {
+		request.Header[key] = values
+	}
 }
 
-func (downloader *TimeoutDownloader) convertToUTF8(bytes []byte) (fullPage string, err error) {
-	// 统一buf为utf编码
-	fullPage = string(bytes)
-	charset := ExtractCharset(fullPage)
+// client 返回网站设置对应的client，第一次使用的时候创建
+func (downloader *TimeoutDownloader) client(setting SiteSetting) *http.Client {
+	key := clientKey{proxy: setting.Proxy, insecureSkipVerify: downloader.insecureSkipVerify || setting.InsecureSkipVerify}
+	if len(key.proxy) == 0 {
+		key.proxy = downloader.proxy
+	}
+
+	downloader.mu.Lock()
+	defer downloader.mu.Unlock()
+	client, ok := downloader.clients[key]
+	if !ok {
+		client = &http.Client{
+			Timeout:   downloader.timeout, //设置超时时间
+			Transport: newTransport(key),
+			Jar:       downloader.jar,
+		}
+		downloader.clients[key] = client
+	}
+	return client
+}
 
-	charset = strings.TrimSpace(charset)
-	charset = strings.ToLower(charset)
+// newTransport 创建保持空闲连接和TLS会话的Transport，有可能的时候使用HTTP/2
+// 压缩由decodeBody处理，这样可以支持br
+func newTransport(key clientKey) *http.Transport {
+	return &http.Transport{
+		Proxy: proxyFunc(key.proxy),
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          DEFAULT_MAX_IDLE_CONNS,
+		MaxIdleConnsPerHost:   DEFAULT_MAX_IDLE_CONNS_PER_HOST,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: key.insecureSkipVerify,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+	}
+}
 
-	// 这样写应该gbk, gb2312...
-	if len(charset) != 0 && charset != "utf-8" && charset != "utf8" {
-		fullPage, err = tool.ConvertToUTF8(fullPage, charset)
-		if err != nil {
-			err = fmt.Errorf("ConvertToUTF8 fail: %v, origin charset: %s", err, charset)
+// decodeBody 按照Content-Encoding解压缩响应
+func decodeBody(encoding string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// 大部分服务器使用zlib格式，少数直接使用deflate
+		buffered := bufio.NewReader(body)
+		if header, err := buffered.Peek(2); err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
 		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return brotli.NewReader(body), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// maxRetries 表示下载失败， 重新尝试的次数
+// 如果maxRetries = 0，那么就下载一次，如果等于1，那么如果下载失败，就会重新再下载一次
+// 如果maxRetries < 0，那么一直重试到超过重试策略的MaxElapsed为止
+// 只有IsRetryable的错误才会重试，ctx结束以后不再重试，直接返回*CancelledError
+func (downloader *TimeoutDownloader) Download(ctx context.Context, url string, maxRetries int) (*Response, error) {
+	return downloader.retryPolicy.retry(ctx, url, maxRetries, func() (*Response, error) {
+		return downloader.doDownload(ctx, url)
+	})
+}
+
+// doDownload 下载一次，所有的错误都是*DownloadError
+func (downloader *TimeoutDownloader) doDownload(ctx context.Context, url string) (*Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, NewDownloadError(url, 0, err)
+	}
+	setting, _ := LookupSiteSetting(request.URL.Host)
+	downloader.setHeaders(request, setting)
+
+	request.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	resp, err := downloader.client(setting).Do(request)
+	if err != nil {
+		return nil, NewDownloadError(url, 0, err)
 	}
-	return
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := NewDownloadError(url, resp.StatusCode, fmt.Errorf("unexpected status %q", resp.Status))
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, err
+	}
+
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), resp.Body)
+	if err != nil {
+		return nil, NewDownloadError(url, resp.StatusCode, err)
+	}
+	bytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, NewDownloadError(url, resp.StatusCode, err)
+	}
+
+	// Body已经解压缩了，去掉和原始内容有关的头
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return &Response{URL: resp.Request.URL.String(), StatusCode: resp.StatusCode, Header: resp.Header, Body: bytes}, nil
 }
 
 func NewDefaultDownloader() Downloader {
@@ -1,24 +1,150 @@
 package engine
 
-import "testing"
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
 
-type DownloaderTestData struct {
-	url      string
-	expected bool
+	"github.com/andybalholm/brotli"
+)
+
+func TestDownloaderCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() //模拟一个永远不响应的主机
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	downloader := NewTimeoutDownloader(time.Minute)
+	_, err := downloader.Download(ctx, server.URL, -1) //无限重试也必须能够结束
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("TestDownloaderCancel: expected *CancelledError, but got %T: %v", err, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("TestDownloaderCancel: expected context.DeadlineExceeded, but got %v", err)
+	}
 }
 
-func TestDefaultDownloader(t *testing.T) {
-	datas := []DownloaderTestData{
-		DownloaderTestData{"http://www.qu.la/book/24868/", true},
-		DownloaderTestData{"http://www.baidu.com/", true},
-		DownloaderTestData{"www.dummy.com", false},
+func TestDownloaderBinary(t *testing.T) {
+	// 图标中可能包含任何字节，不能经过编码转换
+	img := []byte{0x89, 'P', 'N', 'G', 0xff, 0xfe, 0x00, 0xc8, 0xe8}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}))
+	defer server.Close()
+
+	downloader := NewTimeoutDownloader(time.Second)
+	resp, err := downloader.Download(context.Background(), server.URL+"/icon.png", 0)
+	if err != nil {
+		t.Fatalf("TestDownloaderBinary: %v", err)
+	}
+	if !bytes.Equal(resp.Body, img) || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("TestDownloaderBinary: unexpected response %+v", resp)
+	}
+
+	var downloadErr *DownloadError
+	if _, err := downloader.Download(context.Background(), server.URL+"/missing", 0); !errors.As(err, &downloadErr) ||
+		downloadErr.StatusCode != http.StatusNotFound {
+		t.Errorf("TestDownloaderBinary: expected 404 DownloadError, but got %v", err)
 	}
-	downloader := NewDefaultDownloader()
+}
+
+func TestDownloaderCompression(t *testing.T) {
+	page := strings.Repeat("第一章 少年离开了家乡。", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.TrimPrefix(r.URL.Path, "/")
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(w)
+		case "deflate":
+			writer = zlib.NewWriter(w)
+		case "br":
+			writer = brotli.NewWriter(w)
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Write([]byte(page))
+		writer.Close()
+	}))
+	defer server.Close()
 
-	for _, data := range datas {
-		fullPage, _ := downloader.Download(data.url, 5)
-		if (len(fullPage) > 0) != data.expected {
-			t.Errorf("TestDefaultDownloader fail: Test [%s] expected:[%v], actual:[%v]", data.url, data.expected, !data.expected)
+	downloader := NewTimeoutDownloader(time.Second)
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		content, err := DownloadText(context.Background(), downloader, server.URL+"/"+encoding, 0)
+		if err != nil || content != page {
+			t.Errorf("TestDownloaderCompression: %s: unexpected %d bytes, %v", encoding, len(content), err)
 		}
 	}
 }
+
+// perRequestDownloader 和以前的TimeoutDownloader一样，每次下载都创建新的Transport
+type perRequestDownloader struct{}
+
+func (d *perRequestDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Timeout: 5 * time.Second, Transport: tr}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return &Response{URL: url, StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, err
+}
+
+// go test -run=NONE -bench=Chapters ./engine 对比连接复用前后每秒下载的章节数
+func benchmarkChapters(b *testing.B, downloader Downloader) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>" + strings.Repeat("content ", 512) + "</body></html>"))
+	}))
+	defer server.Close()
+
+	b.SetParallelism(THREAD_COUNT / runtime.GOMAXPROCS(0))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := downloader.Download(context.Background(), fmt.Sprintf("%s/%d.html", server.URL, i), 0); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "chapters/s")
+}
+
+func BenchmarkChaptersPerRequestTransport(b *testing.B) {
+	benchmarkChapters(b, &perRequestDownloader{})
+}
+
+func BenchmarkChaptersPooledTransport(b *testing.B) {
+	downloader := NewTimeoutDownloader(5*time.Second, WithInsecureSkipVerify(true))
+	defer downloader.CloseIdleConnections()
+	benchmarkChapters(b, downloader)
+}
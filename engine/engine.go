@@ -4,6 +4,8 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -14,7 +16,7 @@ import (
 
 const (
 	MAX_RETRIES_COUNT                   = -1 //maximum numbers of downloads
-	THREAD_COUNT                        = 15 //the number of threads per download and extract task
+	THREAD_COUNT                        = 15 //default number of workers per download and extract task
 	DEFAULT_THRESHOLD                   = 3  //
 	ENABLE_EXPIRE_THRESHOLD_REMOVE_ITEM = false
 )
@@ -23,6 +25,7 @@ const (
 //The function of engine package is called primarily through this class.
 //This class is not guaranteed to be thread-safe. It depends largely on the implementation of Download and NovelDao.
 //Default Engine object is thread-safe, which is producted by NewDefaultEngine factory function.
+//Every Engine has its own Config, so several engines with different base directories can live in one process.
 type Engine struct {
 	downloader   Downloader //An object that implements Downloader interface. It is a aggregated object and mainly provide download function from  internet
 	dao          Dao        //An object that implements NovelDao interface. It is a aggreated object and mainy provide serialize novel function
@@ -32,34 +35,85 @@ type Engine struct {
 	maxRetries   int        //当下载失败，最大尝试次数
 	iconDirName  string     //图标所在本地目录
 	iconSuffix   string     //图标的后缀
+	workerCount  int        //下载章节时worker的个数
+
+	progressHandler ProgressHandler //接收下载章节时的进度事件，可以为nil
+
+	timeout           time.Duration      //默认下载器的超时时间
+	rateLimit         RateLimit          //默认下载器对没有单独配置的主机的限速
+	retryPolicy       RetryPolicy        //默认下载器的重试策略
+	userAgents        []string           //默认下载器轮流使用的User-Agent
+	downloaderOptions []DownloaderOption //创建默认下载器时候的参数，比如代理
+	cacheTTLs         CacheTTLs          //默认下载器的缓存时间，nil表示不使用缓存
+	cache             *CachingDownloader //默认下载器使用的缓存
+	replayDir         string             //不为空的时候默认下载器在这个目录中记录或者回放响应
+	replayMode        ReplayMode         //默认下载器记录或者回放的方式
+	verbose           bool               //是否输出调试信息
+	config            *Config            //每个Engine自己的配置
+	searcher          *SiteSearcher      //使用config中的忽略主机文件的站内搜索
+	logger            *logging.Logger    //日志对象
 }
 
 //NewEngine is a factory function used to create Engine object
-//downloader - an implementation of Downloader
-//dao - an implementation of NovelDao
-//verbose - enable debug information
-//threshold - threshold time, in second
-//novelDirName - 小说保存的目录名
-//novelSuffix - 小说的扩展名
-//iconDirName - 图标保存的本地目录名
-//iconSuffix - 保存的图标的扩展名
-func NewEngine(downloader Downloader, dao Dao, verbose bool,
-	threshold int64, novelDirName string, novelSuffix string,
-	iconDirName string, iconSuffix string, maxRetries int, baseDirName string) *Engine {
-	configLog(verbose) //配置日志
+//
+//opts - options to configure the engine, e.g. WithDownloader, WithDao, WithWorkerCount.
+//Without any option, the engine uses a TimeoutDownloader, a JsonNovelDao and the current directory.
+func NewEngine(opts ...Option) *Engine {
+	engine := &Engine{threshold: DEFAULT_THRESHOLD, maxRetries: MAX_RETRIES_COUNT, workerCount: THREAD_COUNT,
+		timeout: DEFAULT_TIMEOUT, rateLimit: DEFAULT_RATE_LIMIT, retryPolicy: DEFAULT_RETRY_POLICY,
+		cacheTTLs: DEFAULT_CACHE_TTLS, config: newConfig()}
+	for _, opt := range opts {
+		opt(engine)
+	}
 
-	config.SetBaseDirName(baseDirName) //必须先配置他，然后才能够加载
-	GlobalSiteSearcher.loadIgnoredHosts()
+	if engine.logger == nil {
+		configLog(engine.verbose) //配置日志
+		engine.logger = log
+	}
+	if engine.dao == nil {
+		engine.dao = NewJsonNovelDao()
+	}
+	if len(engine.novelSuffix) > 0 && engine.novelSuffix[0] != '.' {
+		engine.novelSuffix = "." + engine.novelSuffix
+	}
+	if len(engine.iconSuffix) > 0 && engine.iconSuffix[0] != '.' {
+		engine.iconSuffix = "." + engine.iconSuffix
+	}
 
-	if len(novelSuffix) > 0 && novelSuffix[0] != '.' {
-		novelSuffix = "." + novelSuffix
+	//必须先配置他，然后才能够加载
+	if err := engine.config.SetBaseDirName(engine.config.BaseDirName()); err != nil {
+		engine.logger.Errorf("Create base dir %q fail: %v", engine.config.BaseDirName(), err)
 	}
-	if len(iconSuffix) > 0 && iconSuffix[0] != '.' {
-		iconSuffix = "." + iconSuffix
+	if engine.downloader == nil {
+		engine.downloader = engine.newDefaultDownloader()
 	}
-	return &Engine{downloader: downloader, dao: dao, threshold: threshold,
-		novelDirName: novelDirName, novelSuffix: novelSuffix, maxRetries: maxRetries,
-		iconDirName: iconDirName, iconSuffix: iconSuffix}
+	engine.searcher = newEngineSiteSearcher(GlobalSiteSearcher, engine)
+	engine.searcher.loadIgnoredHosts()
+	return engine
+}
+
+// newDefaultDownloader 创建使用基目录中的cookie文件和缓存，并且对每个主机限速的下载器
+func (engine *Engine) newDefaultDownloader() Downloader {
+	timeoutDownloader := NewTimeoutDownloader(engine.timeout, engine.downloaderOptions...)
+	timeoutDownloader.SetUserAgents(engine.userAgents)
+	cookiePath := engine.config.Path(engine.config.CookieFileName())
+	if jar, err := NewPersistentCookieJar(cookiePath); err != nil {
+		engine.logger.Errorf("Load cookies from %q fail: %v", cookiePath, err)
+	} else {
+		timeoutDownloader.SetCookieJar(jar)
+	}
+
+	rateLimitDownloader := NewRateLimitDownloader(timeoutDownloader, engine.rateLimit)
+	rateLimitDownloader.SetRetryPolicy(engine.retryPolicy)
+	var downloader Downloader = rateLimitDownloader
+	if engine.cacheTTLs != nil {
+		engine.cache = NewCachingDownloader(downloader, engine.config.Path(CACHE_DIR_NAME), engine.cacheTTLs)
+		downloader = engine.cache //缓存命中的时候不需要等待限速
+	}
+	if len(engine.replayDir) > 0 {
+		downloader = NewRecordReplayDownloader(downloader, engine.replayDir, engine.replayMode)
+	}
+	return downloader
 }
 
 //NewDefaultEngine is a handy factory function.It produces a thread-safe object, which uses the HttpDownloader object and
@@ -68,9 +122,8 @@ func NewEngine(downloader Downloader, dao Dao, verbose bool,
 //verbose - enable debug information
 func NewDefaultEngine(verbose bool, novelDirName string, novelSuffix string,
 	iconDirName string, iconSuffix string, baseDirName string) *Engine {
-	return NewEngine(NewDefaultDownloader(),
-		NewJsonNovelDao(), verbose, DEFAULT_THRESHOLD, novelDirName, novelSuffix,
-		iconDirName, iconSuffix, MAX_RETRIES_COUNT, baseDirName)
+	return NewEngine(WithVerbose(verbose), WithNovelDir(novelDirName, novelSuffix),
+		WithIconDir(iconDirName, iconSuffix), WithBaseDir(baseDirName))
 }
 
 //Set threshold time of extract base info from url in second
@@ -78,27 +131,41 @@ func (engine *Engine) SetThreshold(threshold int64) {
 	engine.threshold = threshold
 }
 
+//Set the number of workers used to download chapters concurrently.
+//If count <= 0, THREAD_COUNT is used.
+func (engine *Engine) SetWorkerCount(count int) {
+	engine.workerCount = count
+}
+
+//Set the handler which receives progress events while downloading chapters.
+//A nil handler disables progress events.
+func (engine *Engine) SetProgressHandler(handler ProgressHandler) {
+	engine.progressHandler = handler
+}
+
 //NovelByName - Use the novel name to download the content of novel from internet
 //
+//ctx - cancel or set deadline for the download
 //name - novel name
 //return novel finally novel. err is to achieve error information if an error has occurred.
-func (engine *Engine) NovelByName(name string) (novel *Novel, err error) {
-	log.Debugf("Got novel by name %q", name)
-	fullpath := engine.novelDirName + SEP + name + engine.novelSuffix
-	log.Debugf("Fullpath: %q", fullpath)
-	novel, err = engine.dao.LoadNovel(fullpath) //先从本地获取
+func (engine *Engine) NovelByName(ctx context.Context, name string) (novel *Novel, err error) {
+	engine.logger.Debugf("Got novel by name %q", name)
+	novel, err = engine.LoadNovel(name) //先从本地获取
 
 	// 当不存在，再从远程获取
 	if err != nil {
-		urls := engine.SearchSite(name)
-		log.Debug("Got search result:", urls)
+		urls := engine.SearchSite(ctx, name)
+		engine.logger.Debug("Got search result:", urls)
 		for _, u := range urls {
-			log.Debugf("Current use url %q", u)
-			novel, err = engine.NovelByURL(u) //然后从可选的互联网上获取一个，当此互联网不可用或者出现问题的时候，则使用另一个网站
+			engine.logger.Debugf("Current use url %q", u)
+			novel, err = engine.NovelByURL(ctx, u) //然后从可选的互联网上获取一个，当此互联网不可用或者出现问题的时候，则使用另一个网站
 			if err == nil {
-				log.Debugf("Download novel %s done!")
+				engine.logger.Debugf("Download novel %s done!", name)
 				break //表明下载成功
 			}
+			if ctx.Err() != nil {
+				break //已经被取消了，没有必要再尝试其他网站
+			}
 		}
 	}
 	return
@@ -106,61 +173,77 @@ func (engine *Engine) NovelByName(name string) (novel *Novel, err error) {
 
 //NovelByURL - download novel directly from internet
 //return novel finally novel. err is to achieve error information if an error has occurred.
-func (engine *Engine) NovelByURL(url string) (novel *Novel, err error) {
+//If ctx is cancelled before all chapters are downloaded, novel is nil and err is a *CancelledError.
+//Downloaded chapters are kept in a checkpoint, so calling NovelByURL again with the same url
+//only downloads the missing chapters.
+func (engine *Engine) NovelByURL(ctx context.Context, url string) (novel *Novel, err error) {
 	extracter := AutoSelectExtracter(url)
 	if extracter == nil {
-		err = fmt.Errorf("%q extracter not implemented", url)
+		err = newNoExtracterError(url)
 		return
 	}
 
 	novel = new(Novel)
 
 	menuURL := extracter.ExtractMenuURL(url)
-	fullPage, err := engine.downloader.Download(menuURL, engine.maxRetries)
+	fullPage, err := DownloadText(WithResourceKind(ctx, ResourceMenu), engine.downloader, menuURL, engine.maxRetries)
 
 	if err != nil {
+		novel = nil
 		return
 	}
 
 	// 设置互联网上菜单所在的url
 	novel.MenuURL = menuURL
-	log.Debug("MenuURL:", menuURL)
+	engine.logger.Debug("MenuURL:", menuURL)
 
 	// 从fullPage中提取Name, Author, LastUpdateTime
 	engine.constructNovelBase(fullPage, novel, extracter)
 
 	// 从fullPage从提取出所有的菜单
-	engine.constructNovelMenus(fullPage, novel, extracter)
+	if err = engine.constructNovelMenus(ctx, fullPage, novel, extracter); err != nil {
+		novel = nil
+		return
+	}
 
 	// 下来所有的章节到novel.Chapaters中
-	engine.constructNovelChapters(novel, extracter)
+	if err = engine.constructNovelChapters(ctx, novel, extracter); err != nil {
+		novel = nil //不返回只下载了一半的小说
+	}
 	return
 }
 
 // BaseInfoByURL - downlaod the base information of novel directly from internet.
 //
+// ctx - cancel or set deadline for the download
 // url - the url of novel menu page, which is achieved by call SearchSite method
 // novel - finally base information and the field of Chapters is invalid in novel.
 // err - may contain error message
-func (engine *Engine) BaseInfoByURL(netURL string) (novel *Novel, err error) {
+func (engine *Engine) BaseInfoByURL(ctx context.Context, netURL string) (novel *Novel, err error) {
 	addr, err := url.Parse(netURL)
-	CheckError(err)
+	if err != nil {
+		return nil, NewParseError("URL", netURL, err)
+	}
 
 	start := time.Now().Unix()
 	extracter := AutoSelectExtracter(netURL)
 	if extracter == nil {
-		err = fmt.Errorf("%q extracter not implemented", netURL)
+		err = newNoExtracterError(netURL)
 		return
 	}
 
 	novel = new(Novel)
 
 	menuURL := extracter.ExtractMenuURL(netURL)
-	fullPage, err := engine.downloader.Download(menuURL, engine.maxRetries)
+	fullPage, err := DownloadText(WithResourceKind(ctx, ResourceMenu), engine.downloader, menuURL, engine.maxRetries)
 
-	// 出错说明源有问题，那么就移除掉
 	if err != nil {
-		GlobalSiteSearcher.RemoveItem(addr.Host)
+		novel = nil
+		// 出错说明源有问题，那么就移除掉, 但是被取消的话不能说明源有问题
+		var cancelled *CancelledError
+		if !errors.As(err, &cancelled) {
+			engine.searcher.RemoveItem(addr.Host)
+		}
 		return
 	}
 
@@ -171,41 +254,135 @@ func (engine *Engine) BaseInfoByURL(netURL string) (novel *Novel, err error) {
 	end := time.Now().Unix()
 
 	if ENABLE_EXPIRE_THRESHOLD_REMOVE_ITEM && end-start > engine.threshold {
-		GlobalSiteSearcher.RemoveItem(addr.Host)
+		engine.searcher.RemoveItem(addr.Host)
 	}
 	return
 }
 
-func MustSelectSuitableExtracter(url string) (extracter Extracter) {
+// SelectSuitableExtracter returns the extracter registered for url.
+// If no extracter matches, err satisfies errors.Is(err, ErrNoExtracter).
+func SelectSuitableExtracter(url string) (extracter Extracter, err error) {
 	extracter = AutoSelectExtracter(url)
 	if extracter == nil {
-		panic(fmt.Sprintf("Error: Cannot find suitable extract for %q", url))
+		err = newNoExtracterError(url)
 	}
 	return
 }
 
+// MustSelectSuitableExtracter is like SelectSuitableExtracter but panics if no extracter matches.
+// The engine package itself never calls it.
+func MustSelectSuitableExtracter(url string) Extracter {
+	extracter, err := SelectSuitableExtracter(url)
+	if err != nil {
+		panic(err)
+	}
+	return extracter
+}
+
 // SyncNovel - update the content of novel to newest and save novel to native
-func (engine *Engine) SyncNovel(novel *Novel) {
-	log.Info("Sync Novel %q", novel.Name)
-	lastMenuItem := novel.Menus[len(novel.Menus)-1]
+//
+// The menus on internet are compared with the native menus by url and normalized name, so inserted,
+// removed, renamed and moved chapters are all handled. Only added and renamed chapters are downloaded.
+// report - what was added, changed and removed
+// If ctx is cancelled while downloading new chapters, novel is left unchanged and a *CancelledError is returned.
+func (engine *Engine) SyncNovel(ctx context.Context, novel *Novel) (report *SyncReport, err error) {
+	engine.logger.Infof("Sync Novel %q", novel.Name)
+	menuURL := novel.MenuURL
+	if len(menuURL) == 0 && len(novel.Menus) > 0 {
+		menuURL = novel.Menus[len(novel.Menus)-1].URL
+	}
 
-	extracter := MustSelectSuitableExtracter(lastMenuItem.URL)
-	menuPageURL := extracter.ExtractMenuURL(lastMenuItem.URL)
-	menuPage, err := engine.downloader.Download(menuPageURL, engine.maxRetries)
+	extracter, err := SelectSuitableExtracter(menuURL)
+	if err != nil {
+		return
+	}
+	menuPageURL := extracter.ExtractMenuURL(menuURL)
+	menuPage, err := DownloadText(WithResourceKind(ctx, ResourceMenu), engine.downloader, menuPageURL, engine.maxRetries)
+	if err != nil {
+		return
+	}
 
+	newMenus, err := engine.extractMenus(ctx, menuPage, menuPageURL, extracter)
 	if err != nil {
-		panic(fmt.Sprintf("Downlad page [%s] fail: %v", menuPageURL, err))
+		return
+	}
+	if len(newMenus) == 0 && len(novel.Menus) > 0 {
+		// 多半是网站改版，提取失败了，不能因此删除本地所有的章节
+		return nil, NewParseError("MenuList", menuPageURL, errors.New("no menu item found"))
+	}
+	diff := diffMenus(novel.Menus, newMenus)
+	report = &diff.report
+	engine.logger.Infof("Sync novel %q: %d added, %d changed, %d removed", novel.Name,
+		len(report.Added), len(report.Changed), len(report.Removed))
+	if !report.HasChanges() && len(novel.Chapters) == len(novel.Menus) {
+		return
+	}
+
+	//讲新的内容更新到内存和本地
+	if err = engine.doUpdate(ctx, novel, newMenus, diff, extracter); err != nil {
+		return nil, err
+	}
+	novel.LastUpdateTime = extracter.ExtractLastUpdateTime(menuPage)
+	novel.NewestLastChapterName = extracter.ExtractNewestLastChapterName(menuPage)
+	err = engine.SaveNovel(novel) //将内容保存会本地
+	return
+}
+
+// LoadNovel - load novel from native, which mainly depends on the implementation of engine.dao
+func (engine *Engine) LoadNovel(name string) (*Novel, error) {
+	fullpath := engine.novelDirName + SEP + name + engine.novelSuffix
+	engine.logger.Debugf("Fullpath: %q", fullpath)
+	return engine.dao.LoadNovel(fullpath)
+}
+
+// RepairNovel - download the failed or empty chapters of novel again and save novel to native
+//
+// repaired - the number of chapters which are repaired successfully
+// If ctx is cancelled, err is a *CancelledError and novel is not saved.
+func (engine *Engine) RepairNovel(ctx context.Context, novel *Novel) (repaired int, err error) {
+	pending := novel.RepairChapterIndexes()
+	engine.logger.Infof("Repair %d chapters of novel %q", len(pending), novel.Name)
+	if len(pending) == 0 {
+		return
+	}
+
+	extracter, err := SelectSuitableExtracter(novel.MenuURL)
+	if err != nil {
+		return
+	}
+
+	novel.fillPendingChapters()
+	for _, i := range pending {
+		if novel.Chapters[i].Status == ChapterOK || novel.Chapters[i].Status == "" {
+			novel.Chapters[i].Status = ChapterPending //内容为空的章节
+		}
+	}
+	newChapterPool(engine, extracter, OpRepair, novel.Name).run(ctx, novel.Chapters, novel.Menus, pending)
+	if ctx.Err() != nil {
+		err = NewCancelledError("repair novel "+novel.Name, ctx.Err())
+		return
 	}
-	newestLastMenuName := extracter.ExtractNewestLastChapterName(menuPage)
-	if strings.TrimSpace(lastMenuItem.Name) != strings.TrimSpace(newestLastMenuName) {
-		engine.doUpdate(novel, menuPage, menuPageURL, extracter) //讲新的内容更新到内存和本地
-		engine.SaveNovel(novel)                                  //将内容保存会本地
+
+	for _, i := range pending {
+		if !novel.Chapters[i].NeedRepair() {
+			repaired++
+		}
 	}
+	err = engine.SaveNovel(novel)
+	return
 }
 
 // Save - save novel to native, which mainly depends on the implementation of engine.dao
+// The download checkpoint of novel is removed after it is saved successfully.
 func (engine *Engine) SaveNovel(novel *Novel) error {
-	return engine.dao.SaveNovel(novel, engine.novelDirName, engine.novelSuffix)
+	err := engine.dao.SaveNovel(novel, engine.novelDirName, engine.novelSuffix)
+	if err != nil {
+		return err
+	}
+	if err = removeCheckpoint(engine.config, novel.MenuURL); err != nil {
+		engine.logger.Errorf("Remove checkpoint of %q fail: %v", novel.Name, err)
+	}
+	return nil
 }
 
 func (engine *Engine) constructNovelBase(fullPage string, novel *Novel, extracter Extracter) {
@@ -216,179 +393,185 @@ func (engine *Engine) constructNovelBase(fullPage string, novel *Novel, extracte
 	novel.Description = extracter.ExtractNovelDescription(fullPage)
 	novel.IconURL = extracter.ExtractIconURL(fullPage)
 
-	log.Debug("Name", novel.Name)
-	log.Debug("Author:", novel.Author)
-	log.Debug("Last Update time:", novel.LastUpdateTime)
-	log.Debug("Newest chapter:", novel.NewestLastChapterName)
+	engine.logger.Debug("Name", novel.Name)
+	engine.logger.Debug("Author:", novel.Author)
+	engine.logger.Debug("Last Update time:", novel.LastUpdateTime)
+	engine.logger.Debug("Newest chapter:", novel.NewestLastChapterName)
 }
 
-func (engine *Engine) constructNovelMenus(fullPage string, novel *Novel, extracter Extracter) {
-	menus := extracter.ExtractMenuList(fullPage)
-	log.Debug("Menu count:", len(menus))
+func (engine *Engine) constructNovelMenus(ctx context.Context, fullPage string, novel *Novel, extracter Extracter) error {
+	menus, err := engine.extractMenus(ctx, fullPage, novel.MenuURL, extracter)
 	for _, menu := range menus {
+		novel.AddMenu(menu)
+	}
+	return err
+}
+
+// 从menuPage中提取出所有的目录项，目录项的url都是完整的url
+// extracter实现了MenuPager的时候会下载目录后面所有的页面，然后拼接在一起
+func (engine *Engine) extractMenus(ctx context.Context, menuPage string, menuPageURL string, extracter Extracter) (menus []*Menu, err error) {
+	items := extracter.ExtractMenuList(menuPage)
+	pageCount := 1
+	if pager, ok := extracter.(MenuPager); ok {
+		pages, err := engine.nextPages(WithResourceKind(ctx, ResourceMenu), menuPageURL, menuPage, pager.ExtractMenuNextPage, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			items = append(items, extracter.ExtractMenuList(page)...)
+		}
+		pageCount += len(pages)
+	}
+	engine.logger.Debugf("Menu count: %d, pages: %d", len(items), pageCount)
+	for _, item := range items {
 		m := new(Menu)
-		m.URL = engine.joinMenuURLAndChapater(novel.MenuURL, menu[0])
-		m.Name = menu[1]
-		novel.AddMenu(m)
+		m.URL, err = engine.joinMenuURLAndChapater(menuPageURL, item[0])
+		if err != nil {
+			return nil, err
+		}
+		m.Name = item[1]
+		menus = append(menus, m)
+	}
+	if pageCount > 1 {
+		menus = dedupMenus(menus)
 	}
+	return
 }
 
-// 使用多roroutine来下载章节内容
-func (engine *Engine) constructNovelChapters(novel *Novel, extracter Extracter) {
+// 使用工作池来下载章节内容
+// ctx被取消以后，所有的worker都会尽快结束，并且返回*CancelledError
+// 已经下载完毕的章节会记录到检查点中，重新下载同一本小说的时候只会下载检查点中没有的章节
+func (engine *Engine) constructNovelChapters(ctx context.Context, novel *Novel, extracter Extracter) error {
 	chapterCount := len(novel.Menus)
 	novel.Chapters = make([]*Chapter, chapterCount) //预先设置好缓存
 
-	msgChan := make(chan string, THREAD_COUNT)
-	defer close(msgChan)
+	pool := newChapterPool(engine, extracter, OpDownload, novel.Name)
+	ckpt, err := openCheckpoint(engine.config, novel.MenuURL)
+	if err != nil {
+		engine.logger.Errorf("Open checkpoint of %q fail, download without checkpoint: %v", novel.Name, err)
+	} else {
+		defer ckpt.close()
+		pool.checkpoint = ckpt
+		engine.restoreChaptersFromCheckpoint(novel, ckpt)
+	}
+	novel.fillPendingChapters()
 
-	perThreadJobCount := chapterCount / THREAD_COUNT
+	pool.run(ctx, novel.Chapters, novel.Menus, novel.IncompleteChapterIndexes())
 
-	for i := 0; i < THREAD_COUNT; i++ {
-		//每个线程处理[i * len(novel.Menus) / THREAD_COUNT, (i + 1) * len(novel.Menus) / THREAD_COUNT)
-		go engine.constructNovelChaptersT(novel.Chapters[i*perThreadJobCount:(i+1)*perThreadJobCount],
-			novel.Menus[i*perThreadJobCount:(i+1)*perThreadJobCount], msgChan, i, extracter, novel.Name)
+	if ctx.Err() != nil {
+		return NewCancelledError("download novel "+novel.Name, ctx.Err())
 	}
+	return nil
+}
 
-	// 101, [0, 10), [10, 20), ...[90, 100)
-	// 还剩下[100, 101)
-	//这儿处理chapterCount / THREAD_COUNT，不能被整除的情况
-	if (THREAD_COUNT * perThreadJobCount) != chapterCount {
-		go engine.constructNovelChaptersT(novel.Chapters[THREAD_COUNT*perThreadJobCount:chapterCount],
-			novel.Menus[THREAD_COUNT*perThreadJobCount:chapterCount], msgChan, THREAD_COUNT, extracter, novel.Name)
+// 将检查点中已经下载完毕的章节放到novel.Chapters中
+func (engine *Engine) restoreChaptersFromCheckpoint(novel *Novel, ckpt *checkpoint) {
+	chapters, err := ckpt.load()
+	if err != nil {
+		engine.logger.Errorf("Load checkpoint of %q fail: %v", novel.Name, err)
 	}
-
-	// 用来接受goroutine传过来的消息，并且还有个作用就是等待所有线程处理完毕
-	for i := 0; i < chapterCount; i++ {
-		fmt.Printf("<%%%.1f>", float64(i+1)/float64(chapterCount)*100)
-		fmt.Println(<-msgChan)
+	for i, menu := range novel.Menus {
+		if chapter, ok := chapters[menu.URL]; ok {
+			novel.Chapters[i] = chapter
+		}
 	}
-
+	engine.logger.Debugf("Restore %d chapters of %q from checkpoint", len(chapters), novel.Name)
 }
 
-func (engine *Engine) doUpdate(novel *Novel, menuPage string, menuPageURL string, extracter Extracter) {
-	menus := extracter.ExtractMenuList(menuPage)
-	newMenuLen := len(menus)
-	oldMenuLen := len(novel.Menus)
-
-	// 更新新的菜单项
-	for i := oldMenuLen; i < newMenuLen; i++ {
-		menuItem := new(Menu)
-		menuItem.URL = engine.joinMenuURLAndChapater(menuPageURL, menus[i][0])
-		menuItem.Name = menus[i][1]
-		novel.AddMenu(menuItem)
+// doUpdate 使用menuDiff来更新novel，只下载新增加的和改名的章节
+// 新的章节都下载完毕以后才会修改novel，被取消的时候novel保持不变
+func (engine *Engine) doUpdate(ctx context.Context, novel *Novel, newMenus []*Menu, diff *menuDiff,
+	extracter Extracter) error {
+	// 没有变化的和只是url变化的章节直接使用本地的内容
+	chapters := make([]*Chapter, len(newMenus))
+	for i, j := range diff.oldIndex {
+		if j != -1 && j < len(novel.Chapters) && novel.Chapters[j] != nil {
+			chapters[i] = novel.Chapters[j]
+		}
 	}
-
-	// 下面要从网上进行更新
-	toUpdateLen := newMenuLen - oldMenuLen
-	for i := 0; i < toUpdateLen; i++ {
-		novel.AddChapter(new(Chapter)) //先提供空的，然后方便使用多线程来进行更新
+	for _, i := range diff.refetches {
+		chapters[i] = newPendingChapter(newMenus[i])
 	}
-
-	// 把要更新的slice给去取出来
-	updatedMenuSlice := novel.Menus[oldMenuLen:]
-	toUpdateChapterSlice := novel.Chapters[oldMenuLen:]
-
-	msgChan := make(chan string, toUpdateLen)
-	defer close(msgChan)
-
-	chapterCount := toUpdateLen
-	perThreadJobCount := chapterCount / THREAD_COUNT
-	tid := 0
-
-	fmt.Printf("Begining update: %s\n", novel.Name)
-	fmt.Printf("Updated menu list: %v\n", updatedMenuSlice)
-	// chapterCount只要要大于THREAD_COUNT，下面的算法才成立
-	if perThreadJobCount >= 1 {
-		for i := 0; i < THREAD_COUNT; i++ {
-			//每个线程处理[i * len(novel.Menus) / THREAD_COUNT, (i + 1) * len(novel.Menus) / THREAD_COUNT)
-			go engine.constructNovelChaptersT(toUpdateChapterSlice[i*perThreadJobCount:(i+1)*perThreadJobCount],
-				updatedMenuSlice[i*perThreadJobCount:(i+1)*perThreadJobCount], msgChan, tid, extracter, novel.Name)
-			tid = tid + 1
+	for i, menu := range newMenus {
+		if chapters[i] == nil {
+			chapters[i] = newPendingChapter(menu) //本地的小说中缺少的章节
 		}
 	}
 
-	// 101, [0, 10), [10, 20), ...[90, 100)
-	// 还剩下[100, 101)
-	//这儿处理chapterCount / THREAD_COUNT，不能被整除的情况
-	if (THREAD_COUNT * perThreadJobCount) != chapterCount {
-		go engine.constructNovelChaptersT(toUpdateChapterSlice[THREAD_COUNT*perThreadJobCount:chapterCount],
-			updatedMenuSlice[THREAD_COUNT*perThreadJobCount:chapterCount], msgChan, tid, extracter, novel.Name)
-	}
+	engine.logger.Debugf("Refetch chapters: %v", diff.refetches)
+	newChapterPool(engine, extracter, OpUpdate, novel.Name).run(ctx, chapters, newMenus, diff.refetches)
 
-	// 用来接受goroutine传过来的消息，并且还有个作用就是等待所有线程处理完毕
-	for i := 0; i < chapterCount; i++ {
-		fmt.Printf("<%%%g>", float32(i+1)/float32(chapterCount)*100)
-		fmt.Println(<-msgChan)
+	if ctx.Err() != nil {
+		return NewCancelledError("update novel "+novel.Name, ctx.Err())
 	}
-	fmt.Printf("Update %s done!\n", novel.Name)
-}
 
-func (engine *Engine) constructNovelChaptersT(chapters []*Chapter, menus []*Menu, msgChan chan string,
-	tid int, extracter Extracter, name string) {
-	jobCount := len(menus)
-	for i := 0; i < jobCount; i++ {
-		fullPage, err := engine.downloader.Download(menus[i].URL, engine.maxRetries)
-		if err != nil {
-			msgChan <- fmt.Sprintf("[%d] Dowloader.downloader(%s) fail[%s]: %v\n", tid, name, menus[i].URL, err)
-			continue
-		} else {
-			msgChan <- fmt.Sprintf("[%d] Successfully download(%s) chapter %q", tid, name, menus[i].Name)
-		}
-		chapter := new(Chapter)
-		chapter.Title = extracter.ExtractChapterTitle(fullPage)
-		chapter.Content = extracter.ExtractChapterContent(fullPage)
-		chapters[i] = chapter
-	}
+	// 全部下载完毕以后，才更新到novel中
+	novel.Menus = newMenus
+	novel.Chapters = chapters
+	return nil
 }
 
 // 为了处理page是形如/book/4/2222.html形式
 // 和2222.html形式
-func (engine *Engine) joinMenuURLAndChapater(menuURL, page string) string {
+// 出错的时候返回*ParseError
+func (engine *Engine) joinMenuURLAndChapater(menuURL, page string) (string, error) {
 	// 保证menuURL的最后一个元素必须是/
-	if '/' != menuURL[len(menuURL)-1] {
+	if len(menuURL) == 0 || '/' != menuURL[len(menuURL)-1] {
 		menuURL = menuURL + "/"
 	}
 	u, err := url.Parse(menuURL)
 	if err != nil {
-		panic(fmt.Sprintf("joinMenuURLAndChapater fail: %v", err))
+		return "", NewParseError("MenuURL", menuURL, err)
 	}
 
 	root := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
 	page = strings.TrimSpace(page)
+	if len(page) == 0 {
+		return "", NewParseError("ChapterURL", page, errors.New("empty url"))
+	}
 	if page[0] == '/' {
-		return fmt.Sprintf("%s%s", root, page)
+		return fmt.Sprintf("%s%s", root, page), nil
 	} else {
-		return fmt.Sprintf("%s%s", menuURL, page)
+		return fmt.Sprintf("%s%s", menuURL, page), nil
 	}
 }
 
 // SearchSite - search the novel by name
 //
 // return - return multiple urls that novel's download page.
-func (engine *Engine) SearchSite(name string) []string {
-	return GlobalSiteSearcher.Search(name)
+func (engine *Engine) SearchSite(ctx context.Context, name string) []string {
+	return engine.searcher.Search(ctx, name)
 }
 
-func (engine *Engine) DownloadIcon(novel *Novel) (img []byte, err error) {
+func (engine *Engine) DownloadIcon(ctx context.Context, novel *Novel) (img []byte, err error) {
 	host, err := url.Parse(novel.MenuURL)
-	CheckError(err)
+	if err != nil {
+		return nil, NewParseError("MenuURL", novel.MenuURL, err)
+	}
 
 	iconURL := novel.IconURL
+	if len(iconURL) == 0 {
+		return nil, NewParseError("IconURL", iconURL, errors.New("empty url"))
+	}
 	path, err := url.Parse(iconURL)
-	CheckError(err)
+	if err != nil {
+		return nil, NewParseError("IconURL", iconURL, err)
+	}
 
 	fullpath := host.ResolveReference(path)
-	imgStr, err := engine.downloader.Download(fullpath.String(), engine.maxRetries)
-	img = []byte(imgStr)
-	return
+	resp, err := engine.downloader.Download(WithResourceKind(ctx, ResourceIcon), fullpath.String(), engine.maxRetries)
+	if err != nil {
+		return
+	}
+	return resp.Body, nil
 }
 
 func (engine *Engine) SaveIcon(iconName string, img []byte) error {
 	return engine.dao.SaveIcon(img, engine.iconDirName, iconName, engine.iconSuffix)
 }
 
-func (engine *Engine) DownloadAndSaveIcon(novel *Novel) error {
-	img, err := engine.DownloadIcon(novel)
+func (engine *Engine) DownloadAndSaveIcon(ctx context.Context, novel *Novel) error {
+	img, err := engine.DownloadIcon(ctx, novel)
 	if err != nil {
 		return err
 	}
@@ -397,7 +580,25 @@ func (engine *Engine) DownloadAndSaveIcon(novel *Novel) error {
 }
 
 func (engine *Engine) GetLogger() *logging.Logger {
-	return log
+	return engine.logger
+}
+
+// Config returns the config of the engine
+func (engine *Engine) Config() *Config {
+	return engine.config
+}
+
+// IconPath returns the native path of the icon of novel name
+func (engine *Engine) IconPath(name string) string {
+	return engine.iconDirName + SEP + name + engine.iconSuffix
+}
+
+// Cache 返回默认下载器使用的缓存，没有使用缓存的时候返回一个只能用来统计和清理基目录中缓存的对象
+func (engine *Engine) Cache() *CachingDownloader {
+	if engine.cache != nil {
+		return engine.cache
+	}
+	return NewCachingDownloader(nil, engine.config.Path(CACHE_DIR_NAME), engine.cacheTTLs)
 }
 
 func (engine *Engine) GetDownloader() Downloader {
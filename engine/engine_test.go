@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRepairNovel(t *testing.T) {
+	baseDir := t.TempDir()
+	RegisterExtracter(`novel\.test`, &lineExtracter{})
+
+	menuURL := "http://novel.test/book/2/"
+	pages := newTestNovelSite(menuURL, 8)
+	broken := make(map[string]string)
+	for u, page := range pages {
+		broken[u] = page
+	}
+	delete(broken, menuURL+"3.html")
+	delete(broken, menuURL+"5.html")
+
+	engine := newTestEngine(newMapDownloader(broken), WithBaseDir(baseDir), WithNovelDir(t.TempDir(), ""))
+	novel, err := engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	for _, i := range []int{3, 5} {
+		chapter := novel.Chapters[i]
+		if chapter == nil || chapter.Status != ChapterFailed || chapter.LastError == "" || chapter.Attempts != 1 {
+			t.Fatalf("expected chapter %d failed, but got %+v", i, chapter)
+		}
+	}
+	novel.Chapters[6].Content = "" //内容为空的章节也需要重新下载
+	if err = engine.SaveNovel(novel); err != nil {
+		t.Fatalf("SaveNovel fail: %v", err)
+	}
+
+	downloader := newMapDownloader(pages)
+	engine.downloader = downloader
+	novel, err = engine.LoadNovel(novel.Name)
+	if err != nil {
+		t.Fatalf("LoadNovel fail: %v", err)
+	}
+	repaired, err := engine.RepairNovel(context.Background(), novel)
+	if err != nil {
+		t.Fatalf("RepairNovel fail: %v", err)
+	}
+	if repaired != 3 || len(downloader.count) != 3 {
+		t.Errorf("expected 3 chapters repaired, but got %d, downloaded %v", repaired, downloader.count)
+	}
+	for i, chapter := range novel.Chapters {
+		if chapter.NeedRepair() || chapter.Title != fmt.Sprintf("chapter %d", i) {
+			t.Errorf("unexpected chapter %d after repair: %+v", i, chapter)
+		}
+	}
+	if novel.Chapters[3].Attempts != 2 {
+		t.Errorf("expected 2 attempts of chapter 3, but got %d", novel.Chapters[3].Attempts)
+	}
+}
+
+// wrappedCancelDownloader 返回包装以后的*CancelledError
+type wrappedCancelDownloader struct{}
+
+func (wrappedCancelDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	return nil, fmt.Errorf("download %s: %w", url, NewCancelledError("download", context.Canceled))
+}
+
+func TestEngineErrors(t *testing.T) {
+	baseDir := t.TempDir()
+	RegisterExtracter(`novel\.test`, &lineExtracter{})
+	engine := newTestEngine(newMapDownloader(map[string]string{}), WithBaseDir(baseDir))
+	ctx := context.Background()
+
+	if _, err := engine.NovelByURL(ctx, "http://unknown.test/book/1/"); !errors.Is(err, ErrNoExtracter) {
+		t.Errorf("expected ErrNoExtracter, but got %v", err)
+	}
+
+	var parseErr *ParseError
+	if _, err := engine.BaseInfoByURL(ctx, "http://novel.test/%zz"); !errors.As(err, &parseErr) || parseErr.Field != "URL" {
+		t.Errorf("expected *ParseError of URL, but got %v", err)
+	}
+
+	novel := &Novel{Name: "test", MenuURL: "http://novel.test/book/9/", Menus: []*Menu{NewMenu("a", "http://novel.test/book/9/1.html")}}
+	if _, err := engine.SyncNovel(ctx, novel); err == nil {
+		t.Errorf("expected SyncNovel fail")
+	}
+	if _, err := engine.DownloadIcon(ctx, novel); !errors.Is(err, ErrParseFailed) {
+		t.Errorf("expected ErrParseFailed, but got %v", err)
+	}
+
+	_, err := NewTimeoutDownloader(time.Second).Download(ctx, "www.dummy.com", 0)
+	var downloadErr *DownloadError
+	if !errors.Is(err, ErrDownloadFailed) || !errors.As(err, &downloadErr) || downloadErr.URL != "www.dummy.com" {
+		t.Errorf("expected *DownloadError, but got %v", err)
+	}
+
+	// 被包装的取消错误也不能说明源有问题，不能移除搜索源
+	engine = newTestEngine(wrappedCancelDownloader{}, WithBaseDir(baseDir))
+	if _, err := engine.BaseInfoByURL(ctx, "http://novel.test/book/1/"); err == nil {
+		t.Errorf("expected BaseInfoByURL fail")
+	}
+	if engine.searcher.containsIgnoredHost("novel.test") {
+		t.Errorf("novel.test should not be ignored after a cancelled request")
+	}
+
+	var notExist *NovelNotExistError
+	if _, err := engine.LoadNovel("not-exist"); !errors.As(err, &notExist) {
+		t.Errorf("expected *NovelNotExistError, but got %v", err)
+	}
+}
@@ -1,5 +1,7 @@
 package engine
 
+import "strings"
+
 // Novel表示一个小说实体
 type Novel struct {
 	Name                  string     //小说名称
@@ -19,10 +21,22 @@ type Menu struct {
 	URL  string //目录所指向的远程url内容
 }
 
+// ChapterStatus表示章节的下载状态
+type ChapterStatus string
+
+const (
+	ChapterPending ChapterStatus = "pending" //还没有下载
+	ChapterOK      ChapterStatus = "ok"      //已经下载完毕
+	ChapterFailed  ChapterStatus = "failed"  //下载失败，可以使用Engine.RepairNovel重新下载
+)
+
 // Chapter表示小说的一个章节
 type Chapter struct {
-	Title   string //表示章节的标题
-	Content string //表示章节的内容
+	Title     string        //表示章节的标题
+	Content   string        //表示章节的内容
+	Status    ChapterStatus //章节的下载状态, 以前版本保存的小说中为空，表示已经下载完毕
+	LastError string        `json:",omitempty"` //最后一次下载失败的原因
+	Attempts  int           `json:",omitempty"` //总共尝试下载的次数
 }
 
 func (novel *Novel) AddMenu(menu *Menu) {
@@ -38,5 +52,56 @@ func NewMenu(name, url string) *Menu {
 }
 
 func NewChapter(title, content string) *Chapter {
-	return &Chapter{title, content}
+	return &Chapter{Title: title, Content: content, Status: ChapterOK}
+}
+
+// IsComplete 判断章节是否已经下载完毕
+func (chapter *Chapter) IsComplete() bool {
+	return chapter != nil && (chapter.Status == ChapterOK || chapter.Status == "")
+}
+
+// NeedRepair 判断章节是否需要重新下载, 没有下载完毕或者内容为空的章节都需要重新下载
+func (chapter *Chapter) NeedRepair() bool {
+	return !chapter.IsComplete() || len(strings.TrimSpace(chapter.Content)) == 0
+}
+
+// IsChapterComplete 判断第index个章节是否已经下载完毕
+func (novel *Novel) IsChapterComplete(index int) bool {
+	return index < len(novel.Chapters) && novel.Chapters[index].IsComplete()
+}
+
+// IncompleteChapterIndexes 返回所有还没有下载完毕的章节的下标
+func (novel *Novel) IncompleteChapterIndexes() (indexes []int) {
+	for i := 0; i < len(novel.Menus); i++ {
+		if !novel.IsChapterComplete(i) {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+// RepairChapterIndexes 返回所有需要重新下载的章节的下标
+func (novel *Novel) RepairChapterIndexes() (indexes []int) {
+	for i := 0; i < len(novel.Menus); i++ {
+		if i >= len(novel.Chapters) || novel.Chapters[i].NeedRepair() {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+// fillPendingChapters 保证每个目录都有一个对应的章节，没有的使用ChapterPending状态的章节占位
+func (novel *Novel) fillPendingChapters() {
+	for len(novel.Chapters) < len(novel.Menus) {
+		novel.Chapters = append(novel.Chapters, nil)
+	}
+	for i, menu := range novel.Menus {
+		if novel.Chapters[i] == nil {
+			novel.Chapters[i] = newPendingChapter(menu)
+		}
+	}
+}
+
+func newPendingChapter(menu *Menu) *Chapter {
+	return &Chapter{Title: menu.Name, Status: ChapterPending}
 }
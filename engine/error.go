@@ -1,7 +1,21 @@
 package engine
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
+// 可以使用errors.Is来判断的错误
+var (
+	ErrNoExtracter    = errors.New("no suitable extracter")
+	ErrDownloadFailed = errors.New("download failed")
+	ErrParseFailed    = errors.New("parse failed")
+	ErrNoFixture      = errors.New("no recorded fixture") //RecordReplayDownloader中没有记录这个URL
+)
+
+// CheckError panics if err is not nil.
+// It is only suitable for commands, the engine package itself never panics.
 func CheckError(err error) {
 	if err != nil {
 		panic(err)
@@ -19,3 +33,77 @@ func (err *NovelNotExistError) Error() string {
 func NewNovelNotExistError(novelName string) *NovelNotExistError {
 	return &NovelNotExistError{novelName}
 }
+
+// CancelledError 表示一个操作因为ctx被取消或者超时而中断
+// Err是ctx.Err()，所以可以使用errors.Is(err, context.Canceled)来进行判断
+type CancelledError struct {
+	Op  string
+	Err error
+}
+
+func (err *CancelledError) Error() string {
+	return fmt.Sprintf("%s cancelled: %v", err.Op, err.Err)
+}
+
+func (err *CancelledError) Unwrap() error {
+	return err.Err
+}
+
+func NewCancelledError(op string, err error) *CancelledError {
+	return &CancelledError{op, err}
+}
+
+// DownloadError 表示下载失败, errors.Is(err, ErrDownloadFailed)为true
+type DownloadError struct {
+	URL        string
+	StatusCode int           //http状态码，没有收到响应的时候为0
+	RetryAfter time.Duration //429或者503响应中Retry-After要求的等待时间
+	Err        error
+}
+
+func (err *DownloadError) Error() string {
+	if err.StatusCode != 0 {
+		return fmt.Sprintf("download %q fail, status code %d: %v", err.URL, err.StatusCode, err.Err)
+	}
+	return fmt.Sprintf("download %q fail: %v", err.URL, err.Err)
+}
+
+func (err *DownloadError) Unwrap() error {
+	return err.Err
+}
+
+func (err *DownloadError) Is(target error) bool {
+	return target == ErrDownloadFailed
+}
+
+func NewDownloadError(url string, statusCode int, err error) *DownloadError {
+	return &DownloadError{URL: url, StatusCode: statusCode, Err: err}
+}
+
+// ParseError 表示解析某个字段失败，errors.Is(err, ErrParseFailed)为true
+type ParseError struct {
+	Field string //解析失败的字段名称，比如MenuURL
+	Value string
+	Err   error
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("parse %s %q fail: %v", err.Field, err.Value, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+func (err *ParseError) Is(target error) bool {
+	return target == ErrParseFailed
+}
+
+func NewParseError(field string, value string, err error) *ParseError {
+	return &ParseError{field, value, err}
+}
+
+// newNoExtracterError 返回一个errors.Is(err, ErrNoExtracter)为true的错误
+func newNoExtracterError(url string) error {
+	return fmt.Errorf("%w for %q", ErrNoExtracter, url)
+}
@@ -3,11 +3,13 @@ package engine
 import (
 	"net/url"
 	"regexp"
+	"sync"
 )
 
 // 是一些通用的提取方法
 const (
-	CHARSET_PATTERN_SUBMATCH = `\<meta\s+http-equiv="Content-Type"\s+content="text/html;\s*charset\s*=\s*([\s\S]+?)"\s*/\>`
+	// 同时匹配<meta http-equiv="Content-Type" content="text/html; charset=gbk">和<meta charset="gbk">
+	CHARSET_PATTERN_SUBMATCH = `(?i)<meta\s[^>]*?charset\s*=\s*["']?\s*([\w.:-]+)`
 )
 
 var (
@@ -61,25 +63,40 @@ type Extracter interface {
 	ExtractObjURL(name string, searchPage string) (string, bool)
 }
 
-var globalExtracterManager map[string]Extracter
+var (
+	extractersMu           sync.RWMutex
+	globalExtracterManager map[string]Extracter //使用RegisterExtracter注册的Extracter
+	siteExtracters         map[string]Extracter //使用ReplaceSites注册的Extracter，重新加载sites.json的时候整体替换
+)
 
 // 不允许重复注册
 // regexpStr是主机名称正则表达式
 func RegisterExtracter(regexpStr string, extracter Extracter) {
+	extractersMu.Lock()
+	defer extractersMu.Unlock()
 	if _, ok := globalExtracterManager[regexpStr]; !ok {
 		globalExtracterManager[regexpStr] = extracter
 		//log.Debugf("Register Extracter: pattern=%s, extracter=%v", regexpStr, extracter)
 	}
 }
 
+// AutoSelectExtracter 返回主机名称或者URL匹配的Extracter，RegisterExtracter注册的优先
 func AutoSelectExtracter(URL string) Extracter {
-	u, _ := url.Parse(URL)
-	for pat, ext := range globalExtracterManager {
-		if m, _ := regexp.MatchString(pat, u.Host); m {
-			return ext
-		}
-		if m, _ := regexp.MatchString(pat, URL); m {
-			return ext
+	host := ""
+	if u, err := url.Parse(URL); err == nil {
+		host = u.Host
+	}
+
+	extractersMu.RLock()
+	defer extractersMu.RUnlock()
+	for _, extracters := range []map[string]Extracter{globalExtracterManager, siteExtracters} {
+		for pat, ext := range extracters {
+			if m, _ := regexp.MatchString(pat, host); m && len(host) > 0 {
+				return ext
+			}
+			if m, _ := regexp.MatchString(pat, URL); m {
+				return ext
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,299 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileConfig 是配置文件的内容，支持JSON和TOML的一个子集(只有顶层的key = value，参考tomlToJSON)两种格式
+//
+// 零值表示使用NewEngine的默认值，TOML中的key可以写成worker_count的形式
+type FileConfig struct {
+	Verbose     bool
+	BaseDir     string //忽略的主机，检查点等文件所在的目录
+	NovelDir    string
+	NovelSuffix string
+	IconDir     string
+	IconSuffix  string
+	WorkerCount int
+	MaxRetries  *int     //nil表示使用默认值，0表示不重试
+	Timeout     string   //每次下载的超时时间，比如"5s"
+	Threshold   int64    //提取小说基本信息的最长时间，单位为秒
+	UserAgents  []string //默认下载器轮流使用的User-Agent，只能在JSON中配置
+	Proxy       string   //默认下载器使用的代理，参考WithProxy
+
+	InsecureSkipVerify bool //是否对所有网站都不验证https证书
+	NoCache            bool //不在基目录中缓存下载的页面
+
+	SitesConfig string //sites.json的路径，为空的时候在$XDG_CONFIG_HOME/novel，基目录，工作目录和可执行文件所在的目录中查找，engine不使用它
+}
+
+// LoadConfigFile 从path中加载配置，扩展名为.toml的时候按照TOML进行解析，否则按照JSON进行解析
+// TOML中有不支持的内容的时候返回*ParseError，不会忽略它们
+func LoadConfigFile(path string) (*FileConfig, error) {
+	cfg := new(FileConfig)
+	if err := loadConfigFileInto(path, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadConfigFileInto(path string, cfg *FileConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if data, err = tomlToJSON(filepath.Base(path), data); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// tomlKeyPattern 是TOML中的bare key，不支持带引号的key和a.b这样的dotted key
+var tomlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlToJSON 把TOML转换成JSON，这样就可以和JSON共用同一套解析，name只用来生成错误信息
+//
+// 只支持TOML的一个子集：顶层的key = value，值为单行的字符串，整数或者布尔值，可以有#注释。
+// 表，数组，内联表，多行字符串，浮点数和日期等都返回*ParseError，Field为"文件名:行号"，
+// 而不是被错误地解析，这些设置需要使用JSON格式的配置文件
+func tomlToJSON(name string, data []byte) ([]byte, error) {
+	values := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fail := func(reason string) error {
+			return NewParseError(fmt.Sprintf("%s:%d", name, lineno), line, errors.New(reason))
+		}
+		if line[0] == '[' {
+			return nil, fail("tables are not supported, use a json config file")
+		}
+		index := strings.Index(line, "=")
+		if index <= 0 {
+			return nil, fail("expected key = value")
+		}
+		rawKey := strings.TrimSpace(line[:index])
+		if !tomlKeyPattern.MatchString(rawKey) {
+			return nil, fail("only bare keys are supported")
+		}
+		key := strings.ToLower(strings.ReplaceAll(rawKey, "_", "")) //json的key不区分大小写
+		if _, ok := values[key]; ok {
+			return nil, fail("duplicate key " + rawKey)
+		}
+
+		value, err := parseTOMLValue(strings.TrimSpace(line[index+1:]))
+		if err != nil {
+			return nil, fail(err.Error())
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(values)
+}
+
+// parseTOMLValue 解析一个单行的字符串，整数或者布尔值，后面只能有注释
+func parseTOMLValue(value string) (interface{}, error) {
+	var parsed interface{}
+	var rest string
+	switch {
+	case strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''"):
+		return nil, errors.New("multi-line strings are not supported")
+	case strings.HasPrefix(value, `"`):
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return nil, errors.New("invalid string")
+		}
+		if parsed, err = strconv.Unquote(quoted); err != nil {
+			return nil, errors.New("invalid string")
+		}
+		rest = value[len(quoted):]
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return nil, errors.New("invalid string")
+		}
+		parsed, rest = value[1:end+1], value[end+2:]
+	case strings.HasPrefix(value, "["):
+		return nil, errors.New("arrays are not supported, use a json config file")
+	case strings.HasPrefix(value, "{"):
+		return nil, errors.New("inline tables are not supported, use a json config file")
+	default:
+		if index := strings.Index(value, "#"); index >= 0 {
+			value, rest = strings.TrimSpace(value[:index]), value[index:]
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			parsed = n
+		} else if value == "true" || value == "false" {
+			parsed = value == "true"
+		} else {
+			return nil, errors.New("unsupported value " + value)
+		}
+	}
+	if rest = strings.TrimSpace(rest); len(rest) > 0 && rest[0] != '#' {
+		return nil, errors.New("unexpected " + rest)
+	}
+	return parsed, nil
+}
+
+func (cfg *FileConfig) timeout() (time.Duration, error) {
+	if len(cfg.Timeout) == 0 {
+		return 0, nil
+	}
+	return time.ParseDuration(cfg.Timeout)
+}
+
+func (cfg *FileConfig) validate() error {
+	if _, err := cfg.timeout(); err != nil {
+		return err
+	}
+	if len(cfg.Proxy) > 0 && cfg.Proxy != PROXY_DIRECT {
+		if _, err := ParseProxy(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options 把配置转换成NewEngine的参数
+func (cfg *FileConfig) Options() []Option {
+	opts := []Option{WithVerbose(cfg.Verbose), WithNovelDir(cfg.NovelDir, cfg.NovelSuffix),
+		WithIconDir(cfg.IconDir, cfg.IconSuffix)}
+	if len(cfg.BaseDir) > 0 {
+		opts = append(opts, WithBaseDir(cfg.BaseDir))
+	}
+	if cfg.WorkerCount > 0 {
+		opts = append(opts, WithWorkerCount(cfg.WorkerCount))
+	}
+	if cfg.MaxRetries != nil {
+		opts = append(opts, WithMaxRetries(*cfg.MaxRetries))
+	}
+	if timeout, err := cfg.timeout(); err == nil && timeout > 0 {
+		opts = append(opts, WithTimeout(timeout))
+	}
+	if cfg.Threshold > 0 {
+		opts = append(opts, WithThreshold(cfg.Threshold))
+	}
+	if len(cfg.UserAgents) > 0 {
+		opts = append(opts, WithUserAgents(cfg.UserAgents...))
+	}
+	opts = append(opts, WithDownloaderOptions(WithProxy(cfg.Proxy), WithInsecureSkipVerify(cfg.InsecureSkipVerify)))
+	if cfg.NoCache {
+		opts = append(opts, WithCacheTTLs(nil))
+	}
+	return opts
+}
+
+// NewEngineFromFile 根据配置文件创建Engine，opts会覆盖配置文件中的设置
+func NewEngineFromFile(path string, opts ...Option) (*Engine, error) {
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(append(cfg.Options(), opts...)...), nil
+}
+
+// ConfigFlags 把配置文件和命令行共用的参数绑定到一个FlagSet上
+//
+// 优先级：命令行中明确给出的参数 > -c指定的配置文件 > RegisterConfigFlags的默认值
+type ConfigFlags struct {
+	fs         *flag.FlagSet
+	defaults   FileConfig
+	values     FileConfig
+	file       string
+	maxRetries int
+}
+
+// RegisterConfigFlags 在fs上注册-c, -verbose, -d, -e, -id, -ie, -ld, -w, -retries, -dt, -proxy, -nocache, -sites参数
+func RegisterConfigFlags(fs *flag.FlagSet, defaults FileConfig) *ConfigFlags {
+	cf := &ConfigFlags{fs: fs, defaults: defaults, values: defaults}
+	fs.StringVar(&cf.file, "c", "", "load options from a json config file, or a toml one with top-level key = value lines only")
+	fs.BoolVar(&cf.values.Verbose, "verbose", defaults.Verbose, "enable debug information")
+	fs.StringVar(&cf.values.NovelDir, "d", defaults.NovelDir, "the directory of download object")
+	fs.StringVar(&cf.values.NovelSuffix, "e", defaults.NovelSuffix, "the ext name of novel")
+	fs.StringVar(&cf.values.IconDir, "id", defaults.IconDir, "icon native directory")
+	fs.StringVar(&cf.values.IconSuffix, "ie", defaults.IconSuffix, "icon ext name")
+	fs.StringVar(&cf.values.BaseDir, "ld", defaults.BaseDir, "log dir name")
+	fs.IntVar(&cf.values.WorkerCount, "w", defaults.WorkerCount, "the number of workers used to download chapters")
+	fs.IntVar(&cf.maxRetries, "retries", MAX_RETRIES_COUNT, "max retries of every download, negative means retry until the retry policy gives up")
+	fs.StringVar(&cf.values.Timeout, "dt", defaults.Timeout, "timeout of every download, e.g. 5s")
+	fs.BoolVar(&cf.values.NoCache, "nocache", defaults.NoCache, "do not cache downloaded pages in the log dir")
+	fs.StringVar(&cf.values.Proxy, "proxy", defaults.Proxy, "http, https or socks5 proxy, e.g. socks5://127.0.0.1:1080, \"direct\" disables the proxy in environment")
+	fs.StringVar(&cf.values.SitesConfig, "sites", defaults.SitesConfig, "path of sites.json, search $XDG_CONFIG_HOME/novel, the log dir, the working dir and the executable dir by default")
+	return cf
+}
+
+// Config 在fs.Parse之后调用，返回合并后的配置
+func (cf *ConfigFlags) Config() (*FileConfig, error) {
+	cfg := cf.defaults
+	if len(cf.file) > 0 {
+		if err := loadConfigFileInto(cf.file, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	cf.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "verbose":
+			cfg.Verbose = cf.values.Verbose
+		case "d":
+			cfg.NovelDir = cf.values.NovelDir
+		case "e":
+			cfg.NovelSuffix = cf.values.NovelSuffix
+		case "id":
+			cfg.IconDir = cf.values.IconDir
+		case "ie":
+			cfg.IconSuffix = cf.values.IconSuffix
+		case "ld":
+			cfg.BaseDir = cf.values.BaseDir
+		case "w":
+			cfg.WorkerCount = cf.values.WorkerCount
+		case "retries":
+			maxRetries := cf.maxRetries
+			cfg.MaxRetries = &maxRetries
+		case "dt":
+			cfg.Timeout = cf.values.Timeout
+		case "proxy":
+			cfg.Proxy = cf.values.Proxy
+		case "nocache":
+			cfg.NoCache = cf.values.NoCache
+		case "sites":
+			cfg.SitesConfig = cf.values.SitesConfig
+		}
+	})
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// NewEngine 根据合并后的配置创建Engine，opts会覆盖配置中的设置
+func (cf *ConfigFlags) NewEngine(opts ...Option) (*Engine, error) {
+	cfg, err := cf.Config()
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(append(cfg.Options(), opts...)...), nil
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "novel.json")
+	tomlPath := filepath.Join(dir, "novel.toml")
+	os.WriteFile(jsonPath, []byte(`{"NovelDir": "json", "WorkerCount": 3, "MaxRetries": 0, "Timeout": "2s"}`), 0666)
+	os.WriteFile(tomlPath, []byte("# novel\nnovel_dir = \"json\" # \"dir\"\nworker_count = 3 # workers\nmax_retries = 0\ntimeout = '2s'\n"), 0666)
+
+	for _, path := range []string{jsonPath, tomlPath} {
+		cfg, err := LoadConfigFile(path)
+		if err != nil {
+			t.Fatalf("LoadConfigFile(%s) fail: %v", path, err)
+		}
+		engine := NewEngine(append(cfg.Options(), WithLogger(log), WithBaseDir(dir))...)
+		if engine.novelDirName != "json" || engine.workerCount != 3 || engine.maxRetries != 0 ||
+			engine.timeout != 2*time.Second {
+			t.Errorf("%s: unexpected engine %+v", path, engine)
+		}
+	}
+
+	// 命令行中明确给出的参数覆盖配置文件
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := RegisterConfigFlags(fs, FileConfig{NovelDir: "default", IconDir: "icons"})
+	if err := fs.Parse([]string{"-c", jsonPath, "-w", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := configFlags.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NovelDir != "json" || cfg.WorkerCount != 7 || cfg.IconDir != "icons" || cfg.MaxRetries == nil {
+		t.Errorf("unexpected merged config %+v", cfg)
+	}
+}
+
+func TestLoadConfigFileTOMLSubset(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		field   string
+	}{
+		{"worker_count = 3\n[engine]\nworker_count = 4\n", "novel.toml:2"},
+		{"user_agents = [\"a\", \"b\"]\n", "novel.toml:1"},
+		{"# proxy\n\nproxy = { url = \"socks5://127.0.0.1:1080\" }\n", "novel.toml:3"},
+		{"novel_dir = \"\"\"\nnovels\"\"\"\n", "novel.toml:1"},
+		{"threshold = 1.5\n", "novel.toml:1"},
+		{"engine.worker_count = 3\n", "novel.toml:1"},
+		{"novel_dir = \"a\" \"b\"\n", "novel.toml:1"},
+		{"worker_count = 3\nworker_count = 4\n", "novel.toml:2"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "novel.toml")
+		if err := os.WriteFile(path, []byte(test.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		var parseErr *ParseError
+		if _, err := LoadConfigFile(path); !errors.As(err, &parseErr) || parseErr.Field != test.field {
+			t.Errorf("%q: expected a parse error at %s, but got %v", test.content, test.field, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MenuChangeKind 表示一个目录项发生了什么样的变化
+type MenuChangeKind string
+
+const (
+	MenuAdded   MenuChangeKind = "added"   //新增加的目录项
+	MenuRemoved MenuChangeKind = "removed" //网站上已经删除的目录项
+	MenuRenamed MenuChangeKind = "renamed" //url不变，但是标题变了，一般是替换了章节内容(比如替换掉了"请假条")，需要重新下载
+	MenuMoved   MenuChangeKind = "moved"   //标题不变，但是url变了，章节内容不需要重新下载
+)
+
+// MenuChange 表示一个目录项的变化
+type MenuChange struct {
+	Kind     MenuChangeKind
+	OldIndex int   //在旧目录中的下标，MenuAdded为-1
+	NewIndex int   //在新目录中的下标，MenuRemoved为-1
+	Old      *Menu //MenuAdded为nil
+	New      *Menu //MenuRemoved为nil
+}
+
+// SyncReport 是SyncNovel的结果，记录了网站上的目录相对于本地目录的变化
+type SyncReport struct {
+	Added   []MenuChange
+	Changed []MenuChange //MenuRenamed和MenuMoved
+	Removed []MenuChange
+}
+
+// HasChanges 判断目录是否有变化
+func (report *SyncReport) HasChanges() bool {
+	return len(report.Added) > 0 || len(report.Changed) > 0 || len(report.Removed) > 0
+}
+
+// menuDiff 是diffMenus的结果
+type menuDiff struct {
+	report    SyncReport
+	oldIndex  []int //新目录中每一项对应的旧目录中的下标，没有对应的为-1
+	refetches []int //新目录中需要重新下载章节内容的下标
+}
+
+// normalizeMenuName 用来比较两个目录项的标题，忽略空白字符，标点符号和大小写
+func normalizeMenuName(name string) string {
+	var builder strings.Builder
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+// diffMenus 比较旧目录和新目录
+// 先使用url进行匹配，url相同标题不同的为MenuRenamed；剩下的再使用标题进行匹配，标题相同url不同的为MenuMoved
+// 新目录中没有匹配上的为MenuAdded，旧目录中没有匹配上的为MenuRemoved
+func diffMenus(oldMenus, newMenus []*Menu) *menuDiff {
+	diff := &menuDiff{oldIndex: make([]int, len(newMenus))}
+	used := make([]bool, len(oldMenus))
+
+	byURL := make(map[string]int)
+	byName := make(map[string][]int) //标题可能重复，比如多个"请假条"
+	for i, menu := range oldMenus {
+		if _, ok := byURL[menu.URL]; !ok {
+			byURL[menu.URL] = i
+		}
+		name := normalizeMenuName(menu.Name)
+		byName[name] = append(byName[name], i)
+	}
+
+	// 第一遍使用url匹配
+	for i, menu := range newMenus {
+		diff.oldIndex[i] = -1
+		if j, ok := byURL[menu.URL]; ok && !used[j] {
+			used[j] = true
+			diff.oldIndex[i] = j
+			if normalizeMenuName(oldMenus[j].Name) != normalizeMenuName(menu.Name) {
+				diff.report.Changed = append(diff.report.Changed, MenuChange{MenuRenamed, j, i, oldMenus[j], menu})
+				diff.refetches = append(diff.refetches, i)
+			}
+		}
+	}
+
+	// 第二遍使用标题匹配剩下的
+	for i, menu := range newMenus {
+		if diff.oldIndex[i] != -1 {
+			continue
+		}
+		candidates := byName[normalizeMenuName(menu.Name)]
+		for _, j := range candidates {
+			if !used[j] {
+				used[j] = true
+				diff.oldIndex[i] = j
+				diff.report.Changed = append(diff.report.Changed, MenuChange{MenuMoved, j, i, oldMenus[j], menu})
+				break
+			}
+		}
+		if diff.oldIndex[i] == -1 {
+			diff.report.Added = append(diff.report.Added, MenuChange{MenuAdded, -1, i, nil, menu})
+			diff.refetches = append(diff.refetches, i)
+		}
+	}
+
+	for j, menu := range oldMenus {
+		if !used[j] {
+			diff.report.Removed = append(diff.report.Removed, MenuChange{MenuRemoved, j, -1, menu, nil})
+		}
+	}
+	sort.Ints(diff.refetches)
+	return diff
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiffMenus(t *testing.T) {
+	oldMenus := []*Menu{
+		NewMenu("第一章 开始", "http://novel.test/1.html"),
+		NewMenu("请假条", "http://novel.test/2.html"),
+		NewMenu("第二章 继续", "http://novel.test/3.html"),
+		NewMenu("第三章 结束", "http://novel.test/4.html"),
+	}
+	newMenus := []*Menu{
+		NewMenu("第一章  开始", "http://novel.test/1.html"),   //只有空白字符不同
+		NewMenu("第1.5章 插入", "http://novel.test/10.html"), //插入的
+		NewMenu("第二章 继续", "http://novel.test/3.html"),
+		NewMenu("第三章 结束", "http://novel.test/5.html"), //url变化
+		NewMenu("第四章 新的", "http://novel.test/2.html"), //替换掉了请假条
+	}
+
+	diff := diffMenus(oldMenus, newMenus)
+	report := diff.report
+	if len(report.Added) != 1 || report.Added[0].NewIndex != 1 {
+		t.Errorf("unexpected added: %+v", report.Added)
+	}
+	if len(report.Removed) != 0 {
+		t.Errorf("unexpected removed: %+v", report.Removed)
+	}
+	if len(report.Changed) != 2 || report.Changed[0].Kind != MenuRenamed || report.Changed[1].Kind != MenuMoved {
+		t.Errorf("unexpected changed: %+v", report.Changed)
+	}
+	expectedOldIndex := []int{0, -1, 2, 3, 1}
+	for i, j := range expectedOldIndex {
+		if diff.oldIndex[i] != j {
+			t.Errorf("expected oldIndex[%d] = %d, but got %d", i, j, diff.oldIndex[i])
+		}
+	}
+	if len(diff.refetches) != 2 || diff.refetches[0] != 1 || diff.refetches[1] != 4 {
+		t.Errorf("unexpected refetches: %v", diff.refetches)
+	}
+
+	diff = diffMenus(newMenus, newMenus[:2])
+	if len(diff.report.Removed) != 3 || len(diff.report.Added) != 0 || len(diff.report.Changed) != 0 {
+		t.Errorf("unexpected report: %+v", diff.report)
+	}
+}
+
+func TestSyncNovel(t *testing.T) {
+	baseDir := t.TempDir()
+	RegisterExtracter(`novel\.test`, &lineExtracter{})
+
+	menuURL := "http://novel.test/book/3/"
+	pages := newTestNovelSite(menuURL, 5)
+	engine := newTestEngine(newMapDownloader(pages), WithBaseDir(baseDir), WithNovelDir(t.TempDir(), ""))
+	novel, err := engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+
+	// 网站删除了第1章，改了第3章的标题，并且在最后追加了一章
+	pages[menuURL] = "0.html|chapter 0\n2.html|chapter 2\n3.html|chapter 3 (new)\n4.html|chapter 4\n5.html|chapter 5"
+	pages[menuURL+"3.html"] = "chapter 3 (new)\nnew content"
+	pages[menuURL+"5.html"] = "chapter 5\ncontent 5"
+	downloader := newMapDownloader(pages)
+	engine.downloader = downloader
+
+	report, err := engine.SyncNovel(context.Background(), novel)
+	if err != nil {
+		t.Fatalf("SyncNovel fail: %v", err)
+	}
+	if len(report.Added) != 1 || len(report.Changed) != 1 || len(report.Removed) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(novel.Menus) != 5 || len(novel.Chapters) != 5 {
+		t.Fatalf("unexpected menus %d, chapters %d", len(novel.Menus), len(novel.Chapters))
+	}
+	if novel.Chapters[2].Content != "new content" || novel.Chapters[4].Title != "chapter 5" {
+		t.Errorf("unexpected chapters after sync: %+v %+v", novel.Chapters[2], novel.Chapters[4])
+	}
+	if len(downloader.count) != 3 { //目录页面，第3章和第5章
+		t.Errorf("unexpected downloads: %v", downloader.count)
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	DEFAULT_TIMEOUT = 5 * time.Second //默认的下载超时时间
+)
+
+// Option 用来配置NewEngine创建的Engine
+type Option func(engine *Engine)
+
+// WithDownloader 设置下载器，默认使用RateLimitDownloader包装的超时时间为WithTimeout的TimeoutDownloader
+func WithDownloader(downloader Downloader) Option {
+	return func(engine *Engine) {
+		engine.downloader = downloader
+	}
+}
+
+// WithDao 设置小说的序列化方式，默认使用JsonNovelDao
+func WithDao(dao Dao) Option {
+	return func(engine *Engine) {
+		engine.dao = dao
+	}
+}
+
+// WithWorkerCount 设置下载章节时worker的个数，默认为THREAD_COUNT
+func WithWorkerCount(count int) Option {
+	return func(engine *Engine) {
+		engine.workerCount = count
+	}
+}
+
+// WithMaxRetries 设置下载失败时最大的重试次数，小于0表示一直重试到超过重试策略的MaxElapsed，默认为MAX_RETRIES_COUNT
+func WithMaxRetries(maxRetries int) Option {
+	return func(engine *Engine) {
+		engine.maxRetries = maxRetries
+	}
+}
+
+// WithTimeout 设置默认下载器每次下载的超时时间，使用WithDownloader的时候无效
+func WithTimeout(timeout time.Duration) Option {
+	return func(engine *Engine) {
+		engine.timeout = timeout
+	}
+}
+
+// WithRateLimit 设置默认下载器对没有在sites.json中配置的主机的限速，使用WithDownloader的时候无效
+func WithRateLimit(limit RateLimit) Option {
+	return func(engine *Engine) {
+		engine.rateLimit = limit
+	}
+}
+
+// WithRetryPolicy 设置默认下载器的重试策略，使用WithDownloader的时候无效
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(engine *Engine) {
+		engine.retryPolicy = policy
+	}
+}
+
+// WithUserAgents 设置默认下载器轮流使用的User-Agent，使用WithDownloader的时候无效
+func WithUserAgents(userAgents ...string) Option {
+	return func(engine *Engine) {
+		engine.userAgents = userAgents
+	}
+}
+
+// WithDownloaderOptions 设置创建默认下载器时候的参数，比如WithProxy，使用WithDownloader的时候无效
+func WithDownloaderOptions(opts ...DownloaderOption) Option {
+	return func(engine *Engine) {
+		engine.downloaderOptions = append(engine.downloaderOptions, opts...)
+	}
+}
+
+// WithCacheTTLs 设置默认下载器在基目录中缓存的时间，nil表示不使用缓存，默认为DEFAULT_CACHE_TTLS
+// 使用WithDownloader的时候无效
+func WithCacheTTLs(ttls CacheTTLs) Option {
+	return func(engine *Engine) {
+		engine.cacheTTLs = ttls
+	}
+}
+
+// WithRecordReplay 使用RecordReplayDownloader包装默认下载器，在dir中记录或者回放响应
+// 使用WithDownloader的时候无效
+func WithRecordReplay(dir string, mode ReplayMode) Option {
+	return func(engine *Engine) {
+		engine.replayDir = dir
+		engine.replayMode = mode
+	}
+}
+
+// WithThreshold 设置提取小说基本信息的最长时间，单位为秒，默认为DEFAULT_THRESHOLD
+func WithThreshold(threshold int64) Option {
+	return func(engine *Engine) {
+		engine.threshold = threshold
+	}
+}
+
+// WithNovelDir 设置小说保存的目录和扩展名
+func WithNovelDir(dirname string, suffix string) Option {
+	return func(engine *Engine) {
+		engine.novelDirName = dirname
+		engine.novelSuffix = suffix
+	}
+}
+
+// WithIconDir 设置图标保存的目录和扩展名
+func WithIconDir(dirname string, suffix string) Option {
+	return func(engine *Engine) {
+		engine.iconDirName = dirname
+		engine.iconSuffix = suffix
+	}
+}
+
+// WithBaseDir 设置基目录，忽略的主机，检查点等文件都保存在这个目录中，默认为当前目录
+func WithBaseDir(dirname string) Option {
+	return func(engine *Engine) {
+		engine.config.baseDirName = dirname
+	}
+}
+
+// WithLogger 设置Engine使用的日志对象，设置以后NewEngine不会再修改go-logging的全局配置
+func WithLogger(logger *logging.Logger) Option {
+	return func(engine *Engine) {
+		engine.logger = logger
+	}
+}
+
+// WithVerbose 输出调试信息，使用WithLogger的时候无效
+func WithVerbose(verbose bool) Option {
+	return func(engine *Engine) {
+		engine.verbose = verbose
+	}
+}
+
+// WithProgressHandler 设置接收进度事件的handler
+func WithProgressHandler(handler ProgressHandler) Option {
+	return func(engine *Engine) {
+		engine.progressHandler = handler
+	}
+}
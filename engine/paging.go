@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+const (
+	MAX_PAGES = 100 //一个章节或者目录最多跟随的页数，防止网站出错的时候无限翻页
+)
+
+// ChapterPager 是Extracter可选的接口，一个章节分成多页("第1/3页")的网站实现它
+type ChapterPager interface {
+	// ExtractChapterNextPage 返回章节下一页的url，可以是相对的url，没有下一页的时候返回空字符串
+	ExtractChapterNextPage(fullPage string) string
+}
+
+// MenuPager 是Extracter可选的接口，目录分成多页的网站实现它
+type MenuPager interface {
+	// ExtractMenuNextPage 返回目录下一页的url，可以是相对的url，没有下一页的时候返回空字符串
+	ExtractMenuNextPage(fullPage string) string
+}
+
+// nextPages 从第一页开始跟随next返回的链接，依次下载后面所有的页面，返回的页面不包括第一页
+//
+// 下一页已经访问过(循环)，不是http的链接，stop返回true或者达到MAX_PAGES的时候停止。
+// 任何一页下载失败都返回错误，不返回只有一部分的内容
+func (engine *Engine) nextPages(ctx context.Context, firstURL string, firstPage string, next func(string) string,
+	stop func(string) bool) ([]string, error) {
+	visited := map[string]bool{firstURL: true}
+	pages := make([]string, 0)
+	pageURL, fullPage := firstURL, firstPage
+	for len(pages)+1 < MAX_PAGES {
+		ref := strings.TrimSpace(next(fullPage))
+		if len(ref) == 0 {
+			break
+		}
+		nextURL, ok := resolvePageURL(pageURL, ref)
+		if !ok || visited[nextURL] || (stop != nil && stop(nextURL)) {
+			engine.logger.Debugf("Stop following pages of %q at %q", firstURL, ref)
+			break
+		}
+		visited[nextURL] = true
+
+		var err error
+		if fullPage, err = DownloadText(ctx, engine.downloader, nextURL, engine.maxRetries); err != nil {
+			return nil, err
+		}
+		pageURL = nextURL
+		pages = append(pages, fullPage)
+	}
+	return pages, nil
+}
+
+// resolvePageURL 返回ref相对于pageURL的完整url，去掉#后面的部分，ref不是http链接的时候ok为false
+func resolvePageURL(pageURL string, ref string) (string, bool) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// dedupMenus 按照url移除重复的目录项，保留最后一次出现的，多页目录的第一页经常重复最新的章节
+func dedupMenus(menus []*Menu) []*Menu {
+	last := make(map[string]int, len(menus))
+	for i, menu := range menus {
+		last[menu.URL] = i
+	}
+	result := make([]*Menu, 0, len(last))
+	for i, menu := range menus {
+		if last[menu.URL] == i {
+			result = append(result, menu)
+		}
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pagerExtracter 在lineExtracter的基础上支持分页，以"next:"开头的行为下一页的url
+type pagerExtracter struct {
+	lineExtracter
+}
+
+func (e *pagerExtracter) nextPage(fullPage string) string {
+	for _, line := range strings.Split(fullPage, "\n") {
+		if strings.HasPrefix(line, "next:") {
+			return strings.TrimPrefix(line, "next:")
+		}
+	}
+	return ""
+}
+
+func (e *pagerExtracter) ExtractChapterNextPage(fullPage string) string { return e.nextPage(fullPage) }
+func (e *pagerExtracter) ExtractMenuNextPage(fullPage string) string    { return e.nextPage(fullPage) }
+
+func (e *pagerExtracter) ExtractChapterContent(fullPage string) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(e.lineExtracter.ExtractChapterContent(fullPage), "\n") {
+		if !strings.HasPrefix(line, "next:") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *pagerExtracter) ExtractMenuURL(url string) string {
+	return url[:strings.LastIndex(url, "/")+1]
+}
+
+func TestPagedChapters(t *testing.T) {
+	base := "http://novel.test/paged/"
+	pages := map[string]string{
+		base + "1.html":   "chapter 1\npart 1\nnext:1_2.html",
+		base + "1_2.html": "chapter 1 (2/3)\npart 2\nnext:/paged/1_3.html#top",
+		base + "1_3.html": "chapter 1 (3/3)\npart 3\nnext:2.html", //最后一页的下一页是下一章
+		base + "2.html":   "chapter 2\nonly part\nnext:" + base,   //最后一章的下一页是目录页
+		base + "3.html":   "chapter 3\npart 1\nnext:3_2.html",
+		base + "3_2.html": "chapter 3 (2/2)\npart 2\nnext:3.html", //循环
+		base + "4.html":   "chapter 4\npart 1\nnext:4_2.html",
+	}
+	menus := []*Menu{NewMenu("chapter 1", base+"1.html"), NewMenu("chapter 2", base+"2.html"),
+		NewMenu("chapter 3", base+"3.html"), NewMenu("chapter 4", base+"4.html")}
+	downloader := newMapDownloader(pages)
+	engine := newTestEngine(downloader)
+	chapters := make([]*Chapter, len(menus))
+	newChapterPool(engine, &pagerExtracter{}, OpDownload, "test").run(context.Background(), chapters, menus, allIndexes(len(menus)))
+
+	expected := []string{"part 1\npart 2\npart 3", "only part", "part 1\npart 2", ""}
+	for i, chapter := range chapters[:3] {
+		if chapter.Status != ChapterOK || chapter.Title != menus[i].Name || chapter.Content != expected[i] {
+			t.Errorf("chapter %d: unexpected %+v", i, chapter)
+		}
+	}
+	for _, u := range []string{base + "1_2.html", base + "1_3.html", base + "3.html", base + "3_2.html"} {
+		if downloader.count[u] != 1 {
+			t.Errorf("%s downloaded %d times", u, downloader.count[u])
+		}
+	}
+	if downloader.count[base] != 0 {
+		t.Errorf("menu page should not be followed as a chapter page")
+	}
+
+	// 下一页下载失败的时候整个章节失败，不保存只有一部分的内容
+	if chapters[3].Status != ChapterFailed || len(chapters[3].Content) > 0 {
+		t.Errorf("chapter 4: expected to fail, but got %+v", chapters[3])
+	}
+}
+
+func TestPagedMenus(t *testing.T) {
+	RegisterExtracter(`paged\.test`, &pagerExtracter{})
+	menuURL := "http://paged.test/book/"
+	pages := map[string]string{
+		// 第一页的开头重复了最新的章节
+		menuURL:                  "5.html|chapter 5\n1.html|chapter 1\n2.html|chapter 2\nnext:index_2.html",
+		menuURL + "index_2.html": "3.html|chapter 3\n4.html|chapter 4\nnext:index_3.html",
+		menuURL + "index_3.html": "5.html|chapter 5\nnext:" + menuURL, //回到第一页
+	}
+	for i := 1; i <= 5; i++ {
+		pages[fmt.Sprintf("%s%d.html", menuURL, i)] = fmt.Sprintf("chapter %d\ncontent", i)
+	}
+	downloader := newMapDownloader(pages)
+	engine := newTestEngine(downloader, WithBaseDir(t.TempDir()))
+	novel, err := engine.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if len(novel.Menus) != 5 || len(novel.Chapters) != 5 {
+		t.Fatalf("expected 5 menus and chapters, but got %d, %d", len(novel.Menus), len(novel.Chapters))
+	}
+	for i, menu := range novel.Menus {
+		if expected := fmt.Sprintf("chapter %d", i+1); menu.Name != expected || novel.Chapters[i].Title != expected {
+			t.Errorf("menu %d: expected %s, but got %+v", i, expected, menu)
+		}
+	}
+	if downloader.count[menuURL] != 1 {
+		t.Errorf("first menu page downloaded %d times", downloader.count[menuURL])
+	}
+
+	// 目录的下一页下载失败
+	delete(downloader.pages, menuURL+"index_3.html")
+	if _, err := engine.SyncNovel(context.Background(), novel); err == nil {
+		t.Errorf("expected an error when a menu page is missing")
+	}
+}
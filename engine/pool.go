@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// chapterPool 是章节下载的工作池
+// 所有的章节都放到同一个任务队列中，workerCount个worker谁空闲谁就从队列里面取下一个章节，
+// 所以一个下载很慢的章节只会拖住一个worker，不会像按固定切片分配那样拖住整个切片
+type chapterPool struct {
+	engine      *Engine
+	workerCount int
+	extracter   Extracter
+	op          string             //OpDownload, OpUpdate或者OpRepair
+	name        string             //小说名称
+	events      chan ProgressEvent //worker通过他将事件发送给run，由run统一回调ProgressHandler
+	checkpoint  *checkpoint        //下载成功的章节会写到检查点中，可以为nil
+	chapterURLs map[string]bool    //所有章节的url，跟随章节的下一页遇到其它章节的时候停止
+}
+
+func newChapterPool(engine *Engine, extracter Extracter, op string, name string) *chapterPool {
+	workerCount := engine.workerCount
+	if workerCount <= 0 {
+		workerCount = THREAD_COUNT
+	}
+	return &chapterPool{engine: engine, workerCount: workerCount, extracter: extracter,
+		op: op, name: name, events: make(chan ProgressEvent, workerCount)}
+}
+
+// run 下载pending中的下标所对应的章节，并且将结果保存到chapters中对应的位置上
+// chapters和menus长度必须相同，下载失败的章节的状态为ChapterFailed
+// 所有章节处理完毕以后才会返回
+func (pool *chapterPool) run(ctx context.Context, chapters []*Chapter, menus []*Menu, pending []int) {
+	pool.chapterURLs = make(map[string]bool, len(menus))
+	for _, menu := range menus {
+		pool.chapterURLs[menu.URL] = true
+	}
+
+	jobCount := len(pending)
+	jobs := make(chan int, jobCount)
+	for _, i := range pending {
+		jobs <- i
+	}
+	close(jobs)
+
+	stat := &ProgressEvent{Op: pool.op, Novel: pool.name, Total: jobCount}
+	start := time.Now()
+	pool.emit(stat, start, ProgressEvent{Type: EventStarted})
+
+	workerCount := pool.workerCount
+	if workerCount > jobCount {
+		workerCount = jobCount
+	}
+	for tid := 0; tid < workerCount; tid++ {
+		go pool.work(ctx, tid, jobs, chapters, menus)
+	}
+
+	// 用来接受worker传过来的事件，并且还有个作用就是等待所有章节处理完毕
+	for stat.Done < jobCount {
+		event := <-pool.events
+		switch event.Type {
+		case EventChapterSucceeded:
+			stat.Done++
+			stat.Bytes += event.Bytes
+		case EventChapterFailed:
+			stat.Done++
+			stat.Failed++
+		}
+		pool.emit(stat, start, event)
+	}
+	pool.emit(stat, start, ProgressEvent{Type: EventFinished})
+}
+
+// emit 将任务的统计信息填充到event中，然后回调ProgressHandler
+func (pool *chapterPool) emit(stat *ProgressEvent, start time.Time, event ProgressEvent) {
+	handler := pool.engine.progressHandler
+	if handler == nil {
+		return
+	}
+	event.Op = stat.Op
+	event.Novel = stat.Novel
+	event.Done = stat.Done
+	event.Failed = stat.Failed
+	event.Total = stat.Total
+	event.Bytes = stat.Bytes
+	event.Percent = 100
+	if stat.Total > 0 {
+		event.Percent = float64(stat.Done) / float64(stat.Total) * 100
+	}
+	event.Elapsed = time.Since(start)
+	handler(event)
+}
+
+func (pool *chapterPool) work(ctx context.Context, tid int, jobs <-chan int, chapters []*Chapter, menus []*Menu) {
+	engine := pool.engine
+	for i := range jobs {
+		menu := menus[i]
+		pool.events <- ProgressEvent{Type: EventChapterStarted, Worker: tid, Index: i, Chapter: menu.Name, URL: menu.URL}
+
+		attempts := 1
+		if chapters[i] != nil {
+			attempts += chapters[i].Attempts //以前下载失败的次数
+		}
+		retryCtx := withRetryHook(ctx, func(attempt int, err error) {
+			attempts++
+			pool.events <- ProgressEvent{Type: EventRetry, Worker: tid, Index: i, Chapter: menu.Name,
+				URL: menu.URL, Attempt: attempt, Error: err.Error()}
+		})
+		chapter, bytes, err := pool.downloadChapter(retryCtx, menu.URL)
+		if err != nil {
+			chapters[i] = &Chapter{Title: menu.Name, Status: ChapterFailed, LastError: err.Error(), Attempts: attempts}
+			pool.events <- ProgressEvent{Type: EventChapterFailed, Worker: tid, Index: i, Chapter: menu.Name,
+				URL: menu.URL, Attempt: attempts, Error: err.Error()}
+			continue
+		}
+		chapter.Status = ChapterOK
+		chapter.Attempts = attempts
+		chapters[i] = chapter
+		if pool.checkpoint != nil {
+			if err = pool.checkpoint.save(menu.URL, chapter); err != nil {
+				engine.logger.Errorf("Save chapter %q to checkpoint fail: %v", menu.Name, err)
+			}
+		}
+		pool.events <- ProgressEvent{Type: EventChapterSucceeded, Worker: tid, Index: i, Chapter: menu.Name,
+			URL: menu.URL, Bytes: bytes}
+	}
+}
+
+// downloadChapter 下载并且提取章节，bytes为下载的所有页面的大小
+// extracter实现了ChapterPager的时候会下载章节后面所有的页面，然后将内容拼接在一起，
+// 下一页是其它章节或者目录页的时候停止
+// 更新和修复的时候缓存中的章节可能是空的页面或者被替换掉的请假条，所以要重新验证缓存
+func (pool *chapterPool) downloadChapter(ctx context.Context, chapterURL string) (chapter *Chapter, bytes int64, err error) {
+	ctx = WithResourceKind(ctx, ResourceChapter)
+	if pool.op != OpDownload {
+		ctx = WithRevalidate(ctx)
+	}
+	fullPage, err := DownloadText(ctx, pool.engine.downloader, chapterURL, pool.engine.maxRetries)
+	if err != nil {
+		return
+	}
+	chapter = new(Chapter)
+	chapter.Title = pool.extracter.ExtractChapterTitle(fullPage)
+	chapter.Content = pool.extracter.ExtractChapterContent(fullPage)
+	bytes = int64(len(fullPage))
+
+	pager, ok := pool.extracter.(ChapterPager)
+	if !ok {
+		return
+	}
+	menuURL := pool.extracter.ExtractMenuURL(chapterURL)
+	pages, err := pool.engine.nextPages(ctx, chapterURL, fullPage, pager.ExtractChapterNextPage, func(u string) bool {
+		return pool.chapterURLs[u] || u == menuURL
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	contents := []string{chapter.Content}
+	for _, page := range pages {
+		if content := pool.extracter.ExtractChapterContent(page); len(content) > 0 {
+			contents = append(contents, content)
+		}
+		bytes += int64(len(page))
+	}
+	chapter.Content = strings.Join(contents, "\n")
+	return
+}
+
+// allIndexes 返回[0, n)
+func allIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := 0; i < n; i++ {
+		indexes[i] = i
+	}
+	return indexes
+}
@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapDownloader 从内存中返回页面，用来测试engine，不需要访问网络
+type mapDownloader struct {
+	mu    sync.Mutex
+	pages map[string]string
+	delay map[string]time.Duration
+	gate  map[string]chan struct{} //下载这些url的时候一直等到channel被关闭
+	count map[string]int
+}
+
+func newMapDownloader(pages map[string]string) *mapDownloader {
+	return &mapDownloader{pages: pages, delay: make(map[string]time.Duration), gate: make(map[string]chan struct{}),
+		count: make(map[string]int)}
+}
+
+func (d *mapDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	d.mu.Lock()
+	d.count[url]++
+	page, ok := d.pages[url]
+	delay := d.delay[url]
+	gate := d.gate[url]
+	d.mu.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, NewCancelledError("download "+url, ctx.Err())
+		}
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, NewCancelledError("download "+url, ctx.Err())
+		}
+	}
+	if !ok {
+		return nil, NewDownloadError(url, 404, fmt.Errorf("%s not found", url))
+	}
+	return &Response{URL: url, StatusCode: 200, Body: []byte(page)}, nil
+}
+
+// lineExtracter 是一个非常简单的Extracter，页面第一行为标题，剩下的为内容
+type lineExtracter struct{}
+
+func (e *lineExtracter) ExtractNovelName(fullPage string) string                  { return "test" }
+func (e *lineExtracter) ExtractLastUpdateTime(fullPage string) string             { return "" }
+func (e *lineExtracter) ExtractNovelAuthor(fullPage string) string                { return "" }
+func (e *lineExtracter) ExtractNovelDescription(fullPage string) string           { return "" }
+func (e *lineExtracter) ExtractIconURL(menuPage string) string                    { return "" }
+func (e *lineExtracter) ExtractMenuURL(url string) string                         { return url }
+func (e *lineExtracter) ExtractSearchFormHiddenValues(fullPage string) url.Values { return nil }
+func (e *lineExtracter) ExtractSearchFormSearchFieldName(fullPage string) string  { return "" }
+func (e *lineExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
+	return "", false
+}
+func (e *lineExtracter) ExtractSearchFormMethodAndAction(fullPage string) (string, string) {
+	return "", ""
+}
+
+// 菜单页面每一行为 url|name
+func (e *lineExtracter) ExtractMenuList(fullPage string) (menus [][]string) {
+	for _, line := range strings.Split(fullPage, "\n") {
+		if fields := strings.SplitN(line, "|", 2); len(fields) == 2 {
+			menus = append(menus, fields)
+		}
+	}
+	return
+}
+
+func (e *lineExtracter) ExtractNewestLastChapterName(fullPage string) string {
+	menus := e.ExtractMenuList(fullPage)
+	if len(menus) == 0 {
+		return ""
+	}
+	return menus[len(menus)-1][1]
+}
+
+func (e *lineExtracter) ExtractChapterTitle(fullPage string) string {
+	return strings.SplitN(fullPage, "\n", 2)[0]
+}
+
+func (e *lineExtracter) ExtractChapterContent(fullPage string) string {
+	if parts := strings.SplitN(fullPage, "\n", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return ""
+}
+
+func newTestEngine(downloader Downloader, opts ...Option) *Engine {
+	opts = append([]Option{WithDownloader(downloader), WithMaxRetries(0), WithWorkerCount(4), WithLogger(log)}, opts...)
+	return NewEngine(opts...)
+}
+
+func TestChapterPool(t *testing.T) {
+	pages := make(map[string]string)
+	menus := make([]*Menu, 0)
+	for i := 0; i < 37; i++ {
+		u := fmt.Sprintf("http://novel.test/book/%d.html", i)
+		pages[u] = fmt.Sprintf("chapter %d\ncontent %d", i, i)
+		menus = append(menus, NewMenu(fmt.Sprintf("chapter %d", i), u))
+	}
+	// 第一个章节一直阻塞，其它的章节都完成以后才放行，它不能拖住其他章节
+	downloader := newMapDownloader(pages)
+	gate := make(chan struct{})
+	downloader.gate[menus[0].URL] = gate
+	var releaseOnce sync.Once
+	releasedEarly := false
+	release := func(early bool) {
+		releaseOnce.Do(func() {
+			releasedEarly = early
+			close(gate)
+		})
+	}
+	timer := time.AfterFunc(5*time.Second, func() { release(false) })
+	defer timer.Stop()
+
+	engine := newTestEngine(downloader)
+	events := make(map[ProgressEventType]int)
+	var last, lastSucceeded ProgressEvent
+	engine.SetProgressHandler(func(event ProgressEvent) {
+		events[event.Type]++
+		last = event
+		if event.Type == EventChapterSucceeded {
+			lastSucceeded = event
+			if events[EventChapterSucceeded] == len(menus)-1 && event.Index != 0 {
+				release(true)
+			}
+		}
+	})
+	chapters := make([]*Chapter, len(menus))
+	newChapterPool(engine, &lineExtracter{}, OpDownload, "test").run(context.Background(), chapters, menus, allIndexes(len(menus)))
+
+	if !releasedEarly || lastSucceeded.Index != 0 {
+		t.Errorf("TestChapterPool: the other chapters did not finish while chapter 0 was blocked")
+	}
+	if events[EventChapterStarted] != len(menus) || events[EventChapterSucceeded] != len(menus) {
+		t.Errorf("TestChapterPool: unexpected events %v", events)
+	}
+	if last.Type != EventFinished || last.Done != len(menus) || last.Percent != 100 || last.Bytes == 0 {
+		t.Errorf("TestChapterPool: unexpected last event %+v", last)
+	}
+
+	for i, chapter := range chapters {
+		if chapter == nil {
+			t.Fatalf("TestChapterPool: chapter %d is nil", i)
+		}
+		if expected := fmt.Sprintf("chapter %d", i); chapter.Title != expected {
+			t.Errorf("TestChapterPool: expected [%s], but got [%s]", expected, chapter.Title)
+		}
+		if downloader.count[menus[i].URL] != 1 {
+			t.Errorf("TestChapterPool: %s downloaded %d times", menus[i].URL, downloader.count[menus[i].URL])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+// ProgressEventType 表示进度事件的类型
+type ProgressEventType string
+
+const (
+	EventStarted          ProgressEventType = "started"           //开始下载或者更新一本小说
+	EventChapterStarted   ProgressEventType = "chapter_started"   //开始下载一个章节
+	EventChapterSucceeded ProgressEventType = "chapter_succeeded" //一个章节下载成功
+	EventChapterFailed    ProgressEventType = "chapter_failed"    //一个章节下载失败
+	EventRetry            ProgressEventType = "retry"             //下载失败，正在重试
+	EventFinished         ProgressEventType = "finished"          //所有章节都处理完毕
+)
+
+const (
+	OpDownload = "download"
+	OpUpdate   = "update"
+	OpRepair   = "repair"
+)
+
+// ProgressEvent 是Engine在下载章节时发出的进度事件
+// Done, Total, Percent, Bytes, Elapsed是截止到该事件时整个任务的统计信息
+type ProgressEvent struct {
+	Type    ProgressEventType `json:"type"`
+	Op      string            `json:"op"`    //OpDownload, OpUpdate或者OpRepair
+	Novel   string            `json:"novel"` //小说名称
+	Worker  int               `json:"worker"`
+	Index   int               `json:"index"`             //章节在本次任务中的下标
+	Chapter string            `json:"chapter,omitempty"` //章节名称
+	URL     string            `json:"url,omitempty"`
+	Attempt int               `json:"attempt,omitempty"` //EventRetry为重试的次数，EventChapterFailed为总共尝试的次数
+	Error   string            `json:"error,omitempty"`
+	Done    int               `json:"done"`    //已经处理完毕的章节数，包括失败的章节
+	Failed  int               `json:"failed"`  //失败的章节数
+	Total   int               `json:"total"`   //本次任务的章节总数
+	Percent float64           `json:"percent"` //完成的百分比，0-100
+	Bytes   int64             `json:"bytes"`   //已经下载的字节数
+	Elapsed time.Duration     `json:"elapsed"` //任务开始到现在的时间，JSON中单位是纳秒
+}
+
+// ProgressHandler 用来接收进度事件
+// 同一个任务的事件是在同一个goroutine中按顺序回调的，handler中不要做耗时的操作
+type ProgressHandler func(event ProgressEvent)
+
+// retryHookKey 用来通过ctx将重试通知传递给Downloader
+type retryHookKey struct{}
+
+type retryHook func(attempt int, err error)
+
+func withRetryHook(ctx context.Context, hook retryHook) context.Context {
+	return context.WithValue(ctx, retryHookKey{}, hook)
+}
+
+// notifyRetry 由Downloader在每次重试之前调用
+func notifyRetry(ctx context.Context, attempt int, err error) {
+	if hook, ok := ctx.Value(retryHookKey{}).(retryHook); ok {
+		hook(attempt, err)
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	PROXY_DIRECT = "direct" //表示不使用代理，包括环境变量中的代理
+)
+
+// ParseProxy 解析代理地址，支持http, https和socks5三种代理
+func ParseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, NewParseError("Proxy", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, NewParseError("Proxy", proxy, fmt.Errorf("unsupported proxy scheme %q", u.Scheme))
+	}
+	if len(u.Host) == 0 {
+		return nil, NewParseError("Proxy", proxy, fmt.Errorf("missing proxy host"))
+	}
+	return u, nil
+}
+
+// proxyFunc 返回http.Transport使用的代理函数
+func proxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment
+	case PROXY_DIRECT:
+		return nil
+	}
+	u, err := ParseProxy(proxy)
+	if err != nil {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, err
+		}
+	}
+	return http.ProxyURL(u)
+}
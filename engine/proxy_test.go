@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloaderProxy(t *testing.T) {
+	// 代理收到的请求中是完整的URL
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy:" + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	downloader := NewTimeoutDownloader(time.Second, WithProxy(proxy.URL))
+	content, err := DownloadText(context.Background(), downloader, "http://novel.proxy.test/book/1/", 0)
+	if err != nil || content != "proxy:http://novel.proxy.test/book/1/" {
+		t.Errorf("expected request through proxy, but got %q, %v", content, err)
+	}
+
+	// sites.json中的设置优先
+	RegisterSiteSetting(`^broken\.proxy\.test$`, SiteSetting{Proxy: "ftp://127.0.0.1:21"})
+	if _, err := downloader.Download(context.Background(), "http://broken.proxy.test/", 0); err == nil ||
+		!strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Errorf("expected unsupported proxy scheme, but got %v", err)
+	}
+	if _, err := ParseProxy("socks5://127.0.0.1:1080"); err != nil {
+		t.Errorf("ParseProxy(socks5) fail: %v", err)
+	}
+}
+
+func TestDownloaderInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// 默认验证证书，自签名的证书不能通过
+	downloader := NewTimeoutDownloader(time.Second, WithProxy(PROXY_DIRECT))
+	if _, err := downloader.Download(context.Background(), server.URL, 0); err == nil {
+		t.Errorf("expected certificate error")
+	}
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	RegisterSiteSetting("^"+strings.ReplaceAll(host, ".", `\.`)+"$", SiteSetting{InsecureSkipVerify: true})
+	if _, err := downloader.Download(context.Background(), server.URL, 0); err != nil {
+		t.Errorf("expected InsecureSkipVerify for %s, but got %v", host, err)
+	}
+}
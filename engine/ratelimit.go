@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RateLimit 描述对同一个主机的请求限制，字段为0表示不做对应的限制
+type RateLimit struct {
+	RequestsPerSecond float64       //令牌桶每秒生成的令牌数
+	Burst             int           //令牌桶的容量，小于1的时候按1计算
+	MaxConcurrent     int           //同时进行的请求数
+	MinDelay          time.Duration //每次请求之前随机等待[MinDelay, MaxDelay]
+	MaxDelay          time.Duration
+}
+
+// DEFAULT_RATE_LIMIT 是没有在sites.json中配置的主机使用的限制
+var DEFAULT_RATE_LIMIT = RateLimit{RequestsPerSecond: 10, Burst: THREAD_COUNT}
+
+// RateLimitDownloader 是Downloader的装饰器，对每个主机分别进行限速
+//
+// 每一次尝试(包括重试)都要经过限速，所以它自己按照重试策略进行重试，被包装的downloader每次只下载一次
+type RateLimitDownloader struct {
+	downloader  Downloader
+	limit       RateLimit
+	retryPolicy RetryPolicy
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+// NewRateLimitDownloader 创建限速下载器，limit用于没有在sites.json中单独配置的主机
+func NewRateLimitDownloader(downloader Downloader, limit RateLimit) *RateLimitDownloader {
+	return &RateLimitDownloader{downloader: downloader, limit: limit, retryPolicy: DEFAULT_RETRY_POLICY,
+		hosts: make(map[string]*hostLimiter)}
+}
+
+// 设置下载失败以后的重试策略
+func (rd *RateLimitDownloader) SetRetryPolicy(policy RetryPolicy) {
+	rd.retryPolicy = policy
+}
+
+func (rd *RateLimitDownloader) Download(ctx context.Context, url string, maxRetries int) (*Response, error) {
+	limiter := rd.limiter(hostOf(url))
+	return rd.retryPolicy.retry(ctx, url, maxRetries, func() (*Response, error) {
+		release, err := limiter.acquire(ctx)
+		if err != nil {
+			return nil, NewCancelledError("download "+url, err)
+		}
+		defer release()
+		return rd.downloader.Download(ctx, url, 0)
+	})
+}
+
+// limiter 返回host的限速器，sites.json重新加载以后限制变化了的时候创建新的限速器
+// 正在进行的请求仍然使用原来的限速器，网站设置中没有配置RateLimit(比如只配置了Headers)的时候使用默认的限制
+func (rd *RateLimitDownloader) limiter(host string) *hostLimiter {
+	limit := rd.limit
+	if setting, ok := LookupSiteSetting(host); ok && setting.RateLimit != (RateLimit{}) {
+		limit = setting.RateLimit
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	limiter, ok := rd.hosts[host]
+	if !ok || limiter.source != limit {
+		limiter = newHostLimiter(limit)
+		rd.hosts[host] = limiter
+	}
+	return limiter
+}
+
+// hostLimiter 是一个主机的令牌桶和并发限制
+type hostLimiter struct {
+	source RateLimit //创建时的限制，用来判断限制是否变化了
+	limit  RateLimit
+	slots  chan struct{} //MaxConcurrent <= 0的时候为nil
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newHostLimiter(limit RateLimit) *hostLimiter {
+	source := limit
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	limiter := &hostLimiter{source: source, limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+	if limit.MaxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, limit.MaxConcurrent)
+	}
+	return limiter
+}
+
+// acquire 等待直到可以发出请求，返回的release必须在请求结束以后调用
+func (l *hostLimiter) acquire(ctx context.Context) (release func(), err error) {
+	release = func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = func() { <-l.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if err = sleep(ctx, l.reserve()); err == nil {
+		err = sleep(ctx, l.randomDelay())
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// reserve 取走一个令牌，返回需要等待的时间
+func (l *hostLimiter) reserve() time.Duration {
+	if l.limit.RequestsPerSecond <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limit.RequestsPerSecond
+	if l.tokens > float64(l.limit.Burst) {
+		l.tokens = float64(l.limit.Burst)
+	}
+	l.last = now
+
+	l.tokens-- //令牌不够的时候预支，等待令牌生成
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.limit.RequestsPerSecond * float64(time.Second))
+}
+
+func (l *hostLimiter) randomDelay() time.Duration {
+	delay := l.limit.MinDelay
+	if l.limit.MaxDelay > l.limit.MinDelay {
+		delay += time.Duration(rand.Int63n(int64(l.limit.MaxDelay - l.limit.MinDelay)))
+	}
+	return delay
+}
+
+// sleep 等待d，ctx结束的时候提前返回ctx.Err()
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyDownloader 记录同时进行的下载数，前failures次下载失败
+type concurrencyDownloader struct {
+	delay    time.Duration
+	failures int32
+
+	calls    int32
+	inflight int32
+	mu       sync.Mutex
+	max      int32
+}
+
+func (d *concurrencyDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	n := atomic.AddInt32(&d.inflight, 1)
+	defer atomic.AddInt32(&d.inflight, -1)
+	d.mu.Lock()
+	if n > d.max {
+		d.max = n
+	}
+	d.mu.Unlock()
+
+	time.Sleep(d.delay)
+	if atomic.AddInt32(&d.calls, 1) <= d.failures {
+		return nil, NewDownloadError(url, 503, errors.New("busy"))
+	}
+	return &Response{URL: url, StatusCode: 200, Body: []byte("ok")}, nil
+}
+
+func TestRateLimitDownloader(t *testing.T) {
+	if err := RegisterSiteSetting(`^slow\.test$`, SiteSetting{RateLimit: RateLimit{RequestsPerSecond: 50, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterSiteSetting(`^headers\.test$`, SiteSetting{Headers: map[string]string{"Referer": "http://headers.test/"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发限制
+	inner := &concurrencyDownloader{delay: 20 * time.Millisecond}
+	downloader := NewRateLimitDownloader(inner, RateLimit{MaxConcurrent: 2})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			downloader.Download(context.Background(), "http://fast.test/1.html", 0)
+		}()
+	}
+	wg.Wait()
+	if inner.max != 2 {
+		t.Errorf("expected at most 2 concurrent downloads, but got %d", inner.max)
+	}
+
+	// 只配置了Headers的网站仍然使用默认的限制
+	inner = &concurrencyDownloader{delay: 20 * time.Millisecond}
+	downloader = NewRateLimitDownloader(inner, RateLimit{MaxConcurrent: 2})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			downloader.Download(context.Background(), "http://headers.test/1.html", 0)
+		}()
+	}
+	wg.Wait()
+	if inner.max != 2 {
+		t.Errorf("expected the default limit for a headers-only site, but got %d concurrent downloads", inner.max)
+	}
+
+	// sites.json中配置的令牌桶，每次重试都要消耗令牌
+	inner = &concurrencyDownloader{failures: 2}
+	downloader = NewRateLimitDownloader(inner, RateLimit{})
+	downloader.SetRetryPolicy(RetryPolicy{})
+	start := time.Now()
+	if _, err := downloader.Download(context.Background(), "http://slow.test/1.html", 3); err != nil {
+		t.Fatalf("Download fail: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond || inner.calls != 3 {
+		t.Errorf("expected 3 calls in at least 35ms, but got %d calls in %v", inner.calls, elapsed)
+	}
+
+	// 等待令牌的时候ctx结束
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	var cancelled *CancelledError
+	if _, err := downloader.Download(ctx, "http://slow.test/2.html", 0); !errors.As(err, &cancelled) {
+		t.Errorf("expected CancelledError, but got %v", err)
+	}
+}
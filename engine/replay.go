@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	FIXTURE_META_SUFFIX = ".meta.json"
+	FIXTURE_BODY_SUFFIX = ".body"
+)
+
+// ReplayMode 决定RecordReplayDownloader什么时候访问网络
+type ReplayMode int
+
+const (
+	ReplayOnly    ReplayMode = iota //只从fixture中读取，没有记录的URL返回ErrNoFixture，不会访问网络
+	RecordMissing                   //有记录的URL从fixture中读取，没有记录的下载以后记录下来
+	RecordAll                       //总是重新下载，并且覆盖已有的记录
+)
+
+// fixtureMeta 是记录的响应，和内容分开保存，这样可以直接编辑页面
+type fixtureMeta struct {
+	URL        string
+	StatusCode int
+	Header     http.Header `json:",omitempty"`
+}
+
+// RecordReplayDownloader 将真实的响应记录到dir中，然后按照URL进行回放
+//
+// 使用它可以在没有网络的时候得到确定的测试结果。下载失败时网站返回的状态码也会被记录，
+// 回放的时候返回同样的DownloadError。每个URL对应dir中的两个文件，
+// <name>.meta.json保存状态码和响应头，<name>.body保存原始的响应内容
+type RecordReplayDownloader struct {
+	downloader Downloader
+	dir        string
+	mode       ReplayMode
+}
+
+// NewRecordReplayDownloader 创建记录回放下载器，downloader在ReplayOnly模式下可以为nil
+func NewRecordReplayDownloader(downloader Downloader, dir string, mode ReplayMode) *RecordReplayDownloader {
+	return &RecordReplayDownloader{downloader: downloader, dir: dir, mode: mode}
+}
+
+// NewReplayDownloader 创建只从dir中回放的下载器
+func NewReplayDownloader(dir string) *RecordReplayDownloader {
+	return NewRecordReplayDownloader(nil, dir, ReplayOnly)
+}
+
+func (rd *RecordReplayDownloader) Download(ctx context.Context, url string, retries int) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewCancelledError("download "+url, err)
+	}
+
+	path := filepath.Join(rd.dir, fixtureName(url))
+	if rd.mode != RecordAll {
+		resp, err := rd.replay(path, url)
+		if err == nil || rd.mode == ReplayOnly || !errors.Is(err, ErrNoFixture) {
+			return resp, err
+		}
+	}
+	if rd.downloader == nil {
+		return nil, NewDownloadError(url, 0, ErrNoFixture)
+	}
+
+	resp, err := rd.downloader.Download(ctx, url, retries)
+	var downloadErr *DownloadError
+	switch {
+	case err == nil:
+		rd.record(path, &fixtureMeta{URL: url, StatusCode: resp.StatusCode, Header: resp.Header}, resp.Body)
+	case errors.As(err, &downloadErr) && downloadErr.StatusCode != 0:
+		rd.record(path, &fixtureMeta{URL: url, StatusCode: downloadErr.StatusCode}, nil)
+	}
+	return resp, err
+}
+
+// replay 读取url的记录，没有记录的时候返回的错误满足errors.Is(err, ErrNoFixture)
+func (rd *RecordReplayDownloader) replay(path string, url string) (*Response, error) {
+	data, err := os.ReadFile(path + FIXTURE_META_SUFFIX)
+	if os.IsNotExist(err) {
+		return nil, NewDownloadError(url, 0, ErrNoFixture)
+	}
+	if err != nil {
+		return nil, NewDownloadError(url, 0, err)
+	}
+	meta := new(fixtureMeta)
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, NewParseError("Fixture", path+FIXTURE_META_SUFFIX, err)
+	}
+
+	if meta.StatusCode < 200 || meta.StatusCode > 299 {
+		return nil, NewDownloadError(url, meta.StatusCode, errors.New(http.StatusText(meta.StatusCode)))
+	}
+	body, err := os.ReadFile(path + FIXTURE_BODY_SUFFIX)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, NewDownloadError(url, 0, err)
+	}
+	return &Response{URL: url, StatusCode: meta.StatusCode, Header: meta.Header, Body: body}, nil
+}
+
+// record 保存响应，保存失败只记录日志，不影响这次下载
+func (rd *RecordReplayDownloader) record(path string, meta *fixtureMeta, body []byte) {
+	if err := makeDirIfNotExist(rd.dir); err != nil {
+		log.Errorf("Create fixture dir fail: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err == nil && body != nil {
+		err = writeFileAtomic(path+FIXTURE_BODY_SUFFIX, body)
+	}
+	if err == nil {
+		err = writeFileAtomic(path+FIXTURE_META_SUFFIX, append(data, '\n'))
+	}
+	if err != nil {
+		log.Errorf("Record %q fail: %v", meta.URL, err)
+	}
+}
+
+var fixtureNameReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fixtureName 返回url对应的文件名称，前面是便于辨认的主机和路径，后面是url的摘要，保证不会重复
+func fixtureName(url string) string {
+	sum := sha1.Sum([]byte(url))
+	name := url
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	name = strings.Trim(fixtureNameReplacer.ReplaceAllString(name, "_"), "_.")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name + "-" + hex.EncodeToString(sum[:4])
+}
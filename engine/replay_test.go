@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/replay中是手写的www.replay.test的页面，使用gbk编码，和大部分中文小说网站一样，用来检查engine的流程
+// testdata/replay-updated中只有网站更新以后变化的页面
+//
+// testdata/replay-sites中是原来的网络测试访问的真实网站的记录，使用下面的命令重新记录，需要访问网络
+//
+//	go test ./engine -run TestReplaySites -record
+var recordFixtures = flag.Bool("record", false, "record the pages of TestReplaySites from the real sites into testdata/replay-sites")
+
+const (
+	REPLAY_FIXTURE_DIR         = "testdata/replay"
+	REPLAY_UPDATED_FIXTURE_DIR = "testdata/replay-updated"
+	REPLAY_SITES_FIXTURE_DIR   = "testdata/replay-sites"
+	REPLAY_MENU_URL            = "http://www.replay.test/book/7/"
+	REPLAY_NOVEL_NAME          = "回放测试"
+)
+
+var (
+	replayNamePattern    = regexp.MustCompile(`<h1>(.*?)</h1>`)
+	replayAuthorPattern  = regexp.MustCompile(`<p class="author">作者：(.*?)</p>`)
+	replayMenuPattern    = regexp.MustCompile(`<dd><a href="(.*?)">(.*?)</a></dd>`)
+	replayContentPattern = regexp.MustCompile(`(?s)<div id="content">(.*?)</div>`)
+	replayResultPattern  = regexp.MustCompile(`<a class="result" href="(.*?)">(.*?)</a>`)
+)
+
+// replayExtracter 提取www.replay.test的页面
+type replayExtracter struct {
+	lineExtracter
+}
+
+func submatch(pattern *regexp.Regexp, fullPage string) string {
+	if matches := pattern.FindStringSubmatch(fullPage); len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+func (e *replayExtracter) ExtractNovelName(fullPage string) string {
+	return submatch(replayNamePattern, fullPage)
+}
+
+func (e *replayExtracter) ExtractNovelAuthor(fullPage string) string {
+	return submatch(replayAuthorPattern, fullPage)
+}
+
+func (e *replayExtracter) ExtractMenuList(fullPage string) (menus [][]string) {
+	for _, matches := range replayMenuPattern.FindAllStringSubmatch(fullPage, -1) {
+		menus = append(menus, matches[1:])
+	}
+	return
+}
+
+func (e *replayExtracter) ExtractNewestLastChapterName(fullPage string) string {
+	menus := e.ExtractMenuList(fullPage)
+	if len(menus) == 0 {
+		return ""
+	}
+	return menus[len(menus)-1][1]
+}
+
+func (e *replayExtracter) ExtractChapterTitle(fullPage string) string {
+	return submatch(replayNamePattern, fullPage)
+}
+
+func (e *replayExtracter) ExtractChapterContent(fullPage string) string {
+	return submatch(replayContentPattern, fullPage)
+}
+
+func (e *replayExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
+	for _, matches := range replayResultPattern.FindAllStringSubmatch(searchPage, -1) {
+		if matches[2] == name {
+			return matches[1], true
+		}
+	}
+	return "", false
+}
+
+// fallbackDownloader 依次使用每个下载器，直到找到记录为止
+type fallbackDownloader []Downloader
+
+func (downloaders fallbackDownloader) Download(ctx context.Context, url string, retries int) (resp *Response, err error) {
+	for _, downloader := range downloaders {
+		if resp, err = downloader.Download(ctx, url, retries); !errors.Is(err, ErrNoFixture) {
+			return
+		}
+	}
+	return
+}
+
+func newReplayEngine(t *testing.T) *Engine {
+	RegisterExtracter(`replay\.test`, &replayExtracter{})
+	return newTestEngine(NewReplayDownloader(REPLAY_FIXTURE_DIR), WithBaseDir(t.TempDir()), WithNovelDir(t.TempDir(), ""))
+}
+
+func TestRecordReplayDownloader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<h1>首页</h1>"))
+	}))
+	dir := t.TempDir()
+	recorder := NewRecordReplayDownloader(NewTimeoutDownloader(time.Second), dir, RecordMissing)
+	if _, err := recorder.Download(context.Background(), server.URL+"/", 0); err != nil {
+		t.Fatalf("record fail: %v", err)
+	}
+	recorder.Download(context.Background(), server.URL+"/missing", 0)
+	server.Close()
+
+	// 服务器关闭以后仍然可以回放
+	replayer := NewReplayDownloader(dir)
+	if content, err := DownloadText(context.Background(), replayer, server.URL+"/", 0); err != nil || content != "<h1>首页</h1>" {
+		t.Errorf("expected recorded page, but got %q, %v", content, err)
+	}
+	var downloadErr *DownloadError
+	if _, err := replayer.Download(context.Background(), server.URL+"/missing", 0); !errors.As(err, &downloadErr) ||
+		downloadErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected recorded 404, but got %v", err)
+	}
+	if _, err := replayer.Download(context.Background(), server.URL+"/unknown", 0); !errors.Is(err, ErrNoFixture) || IsRetryable(err) {
+		t.Errorf("expected ErrNoFixture, but got %v", err)
+	}
+}
+
+func TestExtractCharset(t *testing.T) {
+	resp, err := NewReplayDownloader(REPLAY_FIXTURE_DIR).Download(context.Background(), "http://www.replay.test/", 0)
+	if err != nil {
+		t.Fatalf("TestExtractCharset: %v", err)
+	}
+	if charset := ExtractCharset(string(resp.Body)); charset != "gbk" {
+		t.Errorf("TestExtractCharset: expected [gbk], but got [%s]", charset)
+	}
+	if content, err := resp.Text(); err != nil || !strings.Contains(content, REPLAY_NOVEL_NAME) {
+		t.Errorf("TestExtractCharset: page is not decoded: %q, %v", content, err)
+	}
+}
+
+func TestReplayNovelByURL(t *testing.T) {
+	engine := newReplayEngine(t)
+	novel, err := engine.NovelByURL(context.Background(), REPLAY_MENU_URL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if novel.Name != REPLAY_NOVEL_NAME || novel.Author != "佚名" || len(novel.Chapters) != 3 {
+		t.Fatalf("unexpected novel %q by %q with %d chapters", novel.Name, novel.Author, len(novel.Chapters))
+	}
+	if chapter := novel.Chapters[1]; chapter.Title != "第二章 夜雨" || chapter.Content != "夜里下起了雨。" || !chapter.IsComplete() {
+		t.Errorf("unexpected chapter: %+v", chapter)
+	}
+
+	var downloadErr *DownloadError
+	if _, err := engine.NovelByURL(context.Background(), "http://www.replay.test/book/404/"); !errors.As(err, &downloadErr) ||
+		downloadErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected recorded 404, but got %v", err)
+	}
+}
+
+func TestReplaySyncNovel(t *testing.T) {
+	engine := newReplayEngine(t)
+	novel, err := engine.NovelByURL(context.Background(), REPLAY_MENU_URL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+
+	// 网站修改了第三章的标题，并且增加了第四章
+	engine.downloader = fallbackDownloader{NewReplayDownloader(REPLAY_UPDATED_FIXTURE_DIR), NewReplayDownloader(REPLAY_FIXTURE_DIR)}
+	report, err := engine.SyncNovel(context.Background(), novel)
+	if err != nil {
+		t.Fatalf("SyncNovel fail: %v", err)
+	}
+	if len(report.Added) != 1 || len(report.Changed) != 1 || len(report.Removed) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(novel.Chapters) != 4 || novel.Chapters[2].Title != "第三章 天晴（修订）" || novel.Chapters[3].Content != "故事结束了。" {
+		t.Errorf("unexpected chapters after sync: %+v %+v", novel.Chapters[2], novel.Chapters[3])
+	}
+}
+
+func TestReplaySearch(t *testing.T) {
+	engine := newReplayEngine(t)
+	engine.searcher.AddItem("http://www.replay.test/search?q=%s", true, true, "www.replay.test")
+
+	result := engine.SearchSite(context.Background(), REPLAY_NOVEL_NAME)
+	if len(result) != 1 || result[0] != REPLAY_MENU_URL {
+		t.Errorf("expected [%s], but got %v", REPLAY_MENU_URL, result)
+	}
+	if result := engine.SearchSite(context.Background(), "不存在"); len(result) != 0 {
+		t.Errorf("expected nothing, but got %v", result)
+	}
+}
+
+// replaySites 是原来的网络测试访问的页面，charset为空的时候只检查页面不为空
+var replaySites = []struct {
+	url     string
+	charset string
+}{
+	{"http://www.37zw.net/", "gbk"},
+	{"http://www.qu.la/book/24868/", ""},
+	{"http://www.baidu.com/", ""},
+}
+
+// TestReplaySites 回放真实网站的记录，没有记录的页面跳过
+func TestReplaySites(t *testing.T) {
+	var downloader Downloader = NewReplayDownloader(REPLAY_SITES_FIXTURE_DIR)
+	if *recordFixtures {
+		downloader = NewRecordReplayDownloader(NewTimeoutDownloader(DEFAULT_TIMEOUT), REPLAY_SITES_FIXTURE_DIR, RecordAll)
+	}
+	for _, site := range replaySites {
+		t.Run(hostOf(site.url), func(t *testing.T) {
+			resp, err := downloader.Download(context.Background(), site.url, 3)
+			if errors.Is(err, ErrNoFixture) {
+				t.Skipf("%s is not recorded, run go test ./engine -run TestReplaySites -record", site.url)
+			}
+			if err != nil || len(resp.Body) == 0 {
+				t.Fatalf("expected the page of %s, but got %v", site.url, err)
+			}
+			if charset := ExtractCharset(string(resp.Body)); len(site.charset) > 0 && charset != site.charset {
+				t.Errorf("expected charset [%s], but got [%s]", site.charset, charset)
+			}
+		})
+	}
+}
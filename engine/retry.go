@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy 决定下载失败以后是否重试，以及重试之前等待多长时间
+//
+// 第n次重试之前等待InitialBackoff * Multiplier^(n-1)，不超过MaxBackoff，
+// 然后随机减去其中最多Jitter比例的时间，避免所有worker同时重试
+type RetryPolicy struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64       //小于1的时候按1计算
+	Jitter         float64       //[0, 1]
+	MaxElapsed     time.Duration //从第一次尝试开始，超过这个时间就不再重试，0表示不限制
+}
+
+// DEFAULT_RETRY_POLICY 是TimeoutDownloader和RateLimitDownloader默认的重试策略
+// maxRetries < 0 的时候，最多重试MaxElapsed这么长的时间
+var DEFAULT_RETRY_POLICY = RetryPolicy{
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.5,
+	MaxElapsed:     2 * time.Minute,
+}
+
+// Backoff 返回第attempt次尝试失败以后，重试之前需要等待的时间
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := math.Max(policy.Multiplier, 1)
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	if jitter := math.Min(math.Max(policy.Jitter, 0), 1); jitter > 0 {
+		backoff -= backoff * jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable 判断下载错误是否值得重试
+//
+// 429, 408和5xx响应，超时，连接被重置等临时错误需要重试；
+// DNS解析失败，404等其它4xx响应，非法的URL，ctx结束都不重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var cancelled *CancelledError
+	if errors.As(err, &cancelled) {
+		return false
+	}
+
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) && downloadErr.StatusCode != 0 {
+		code := downloadErr.StatusCode
+		return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true //连接被拒绝，连接被重置等
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryAfter 返回错误中服务器要求的等待时间
+func retryAfter(err error) time.Duration {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter 解析Retry-After头，支持秒数和http日期两种格式
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retry 调用download直到成功，遇到不能重试的错误，重试了maxRetries次或者超过MaxElapsed为止
+// maxRetries < 0 表示只受MaxElapsed的限制
+func (policy RetryPolicy) retry(ctx context.Context, url string, maxRetries int,
+	download func() (*Response, error)) (resp *Response, err error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return nil, NewCancelledError("download "+url, ctx.Err())
+		}
+		resp, err = download()
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return nil, NewCancelledError("download "+url, ctx.Err())
+		}
+		if !IsRetryable(err) || (maxRetries >= 0 && attempt > maxRetries) {
+			return
+		}
+
+		wait := policy.Backoff(attempt)
+		if after := retryAfter(err); after > wait {
+			wait = after
+		}
+		if policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed {
+			return
+		}
+		log.Debugf("Retry download %q after %v, attempt: %d, err: %v", url, wait, attempt, err)
+		notifyRetry(ctx, attempt, err)
+		if sleep(ctx, wait) != nil {
+			return nil, NewCancelledError("download "+url, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeoutDownloaderRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/busy":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	downloader := NewTimeoutDownloader(time.Second)
+	downloader.SetRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond, Multiplier: 2, MaxElapsed: time.Second})
+
+	// 5xx需要重试，maxRetries < 0 的时候也会成功
+	if content, err := DownloadText(context.Background(), downloader, server.URL+"/flaky", -1); err != nil || content != "ok" {
+		t.Errorf("expected ok, but got %q, %v", content, err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, but got %d", calls)
+	}
+
+	// 404不重试
+	atomic.StoreInt32(&calls, 0)
+	_, err := downloader.Download(context.Background(), server.URL+"/missing", 5)
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) || downloadErr.StatusCode != http.StatusNotFound || calls != 1 {
+		t.Errorf("expected one 404 DownloadError, but got %v after %d calls", err, calls)
+	}
+
+	// Retry-After超过了MaxElapsed，不再重试
+	atomic.StoreInt32(&calls, 0)
+	start := time.Now()
+	_, err = downloader.Download(context.Background(), server.URL+"/busy", -1)
+	if !errors.As(err, &downloadErr) || downloadErr.RetryAfter != time.Hour || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("expected one 429 DownloadError with Retry-After, but got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		if backoff := policy.Backoff(attempt); backoff > max || backoff < max/2 {
+			t.Errorf("Backoff(%d) = %v, expected in [%v, %v]", attempt, backoff, max/2, max)
+		}
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("120", now); d != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %v", d)
+	}
+	if d := parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now); d != time.Minute {
+		t.Errorf("parseRetryAfter(date) = %v", d)
+	}
+
+	dnsErr := NewDownloadError("http://nohost.test/", 0, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host"}})
+	if IsRetryable(dnsErr) {
+		t.Errorf("dns error should not be retried")
+	}
+	timeoutErr := NewDownloadError("http://novel.test/", 0, context.DeadlineExceeded)
+	if !IsRetryable(timeoutErr) || IsRetryable(NewCancelledError("download", context.Canceled)) {
+		t.Errorf("unexpected retryable classification")
+	}
+}
@@ -1,14 +1,18 @@
 package engine
 
+import "context"
 import "fmt"
 import "os"
 
 import "bufio"
+import "sync"
+
+import "github.com/op/go-logging"
 
 import "github.com/twoflyliu/novel/tool"
 
 type Searcher interface {
-	Search(name string) []string
+	Search(ctx context.Context, name string) []string
 }
 
 type SearcherItem struct {
@@ -21,33 +25,58 @@ type SearcherItem struct {
 }
 
 // 表示站内搜索
+//
+// GlobalSiteSearcher保存所有extracter注册的搜索源；每个Engine持有一个以它为parent的
+// SiteSearcher，只在自己的忽略列表中记录失效的主机，不会影响其它Engine
 type SiteSearcher struct {
+	mu          sync.Mutex
 	items       []*SearcherItem
+	siteItems   []*SearcherItem //使用ReplaceSites注册的搜索源，重新加载sites.json的时候整体替换
 	ignoredHost []string
+
+	parent *SiteSearcher //不为nil的时候搜索源来自parent
+	engine *Engine       //不为nil的时候使用engine的配置，下载器和日志
 }
 
 var GlobalSiteSearcher *SiteSearcher
 
+// newEngineSiteSearcher 创建engine私有的站内搜索，搜索源从parent中读取
+func newEngineSiteSearcher(parent *SiteSearcher, engine *Engine) *SiteSearcher {
+	return &SiteSearcher{parent: parent, engine: engine, ignoredHost: make([]string, 0)}
+}
+
+// NewSearcherItem 创建一个搜索源，fmtSearchString中的%s会被替换成小说的名称
+func NewSearcherItem(fmtSearchString string, escape bool, gbk bool, host string) *SearcherItem {
+	return &SearcherItem{fmtSearchString, escape, gbk, host}
+}
+
 func (ss *SiteSearcher) AddItem(fmtSearchString string, escape bool, gbk bool, host string) {
-	ss.items = append(ss.items, &SearcherItem{fmtSearchString, escape, gbk, host})
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.items = append(ss.items, NewSearcherItem(fmtSearchString, escape, gbk, host))
 }
 
 func (ss *SiteSearcher) RemoveItem(host string) {
-	index := -1
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 
-	if len(ss.items) == 1 { //防止被删光了
-		return
-	}
+	if ss.parent == nil {
+		index := -1
 
-	for i := 0; i < len(ss.items); i++ {
-		if host == ss.items[i].host {
-			index = i
-			break
+		if len(ss.items) == 1 { //防止被删光了
+			return
+		}
+
+		for i := 0; i < len(ss.items); i++ {
+			if host == ss.items[i].host {
+				index = i
+				break
+			}
 		}
-	}
 
-	if index != -1 {
-		ss.items = append(ss.items[0:index], ss.items[index+1:]...) //slice 删除某个位置上的元素
+		if index != -1 {
+			ss.items = append(ss.items[0:index], ss.items[index+1:]...) //slice 删除某个位置上的元素
+		}
 	}
 
 	// 将要移除的软件源保存到本地文件中
@@ -58,9 +87,35 @@ func (ss *SiteSearcher) RemoveItem(host string) {
 }
 
 func (ss *SiteSearcher) RemoveAllIgnoredHost() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	ss.ignoredHost = []string{}
 }
 
+// searchItems 返回当前可用的搜索源，如果全部被忽略了就返回全部的搜索源
+func (ss *SiteSearcher) searchItems() []*SearcherItem {
+	var items []*SearcherItem
+	if ss.parent != nil {
+		items = ss.parent.searchItems()
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	items = append(items, ss.items...)
+	items = append(items, ss.siteItems...)
+
+	result := make([]*SearcherItem, 0, len(items))
+	for _, item := range items {
+		if !ss.containsIgnoredHost(item.host) {
+			result = append(result, item)
+		}
+	}
+	if len(result) == 0 { //防止被删光了
+		return items
+	}
+	return result
+}
+
 func (ss *SiteSearcher) containsIgnoredHost(host string) bool {
 	for i := 0; i < len(ss.ignoredHost); i++ {
 		if host == ss.ignoredHost[i] {
@@ -70,35 +125,57 @@ func (ss *SiteSearcher) containsIgnoredHost(host string) bool {
 	return false
 }
 
+func (ss *SiteSearcher) logger() *logging.Logger {
+	if ss.engine != nil {
+		return ss.engine.logger
+	}
+	return log
+}
+
+func (ss *SiteSearcher) ignoredHostFilePath() string {
+	if ss.engine != nil {
+		return ss.engine.config.Path(ss.engine.config.IgnoredHostFileName())
+	}
+	cfg := newConfig()
+	return cfg.Path(cfg.IgnoredHostFileName())
+}
+
 func (ss *SiteSearcher) appendIgnoreHostToNative(host string) {
-	ignoredHostFilePath := config.BaseDirName() + "/" + config.IgnoredHostFileName()
+	ignoredHostFilePath := ss.ignoredHostFilePath()
 	file, err := os.OpenFile(ignoredHostFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-	CheckError(err)
+	if err != nil {
+		ss.logger().Errorf("Save ignored host %q fail: %v", host, err)
+		return
+	}
 	defer file.Close()
 	file.Write([]byte(host))
 	file.Write([]byte("\n"))
 }
 
 func (ss *SiteSearcher) loadIgnoredHosts() {
-	log.Debug("Load Ignored hosts from native file")
-	ignoredHostFilePath := config.BaseDirName() + "/" + config.IgnoredHostFileName()
+	ss.logger().Debug("Load Ignored hosts from native file")
+	ignoredHostFilePath := ss.ignoredHostFilePath()
 	file, err := os.Open(ignoredHostFilePath)
 	if err != nil {
-		log.Debugf("%q not exist", ignoredHostFilePath)
+		ss.logger().Debugf("%q not exist", ignoredHostFilePath)
 		return
 	}
 	defer file.Close()
 
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
 	buff := bufio.NewReader(file)
 	for {
 		host, err := buff.ReadString('\n')
 		if err != nil {
 			break
 		}
-		host = host[0 : len(host)-1]
-		log.Debug("Ignored host:", host)
-		ss.ignoredHost = append(ss.ignoredHost, host) //去除换行符
-		ss.RemoveItem(host)                           //移除不好的搜索源
+		host = host[0 : len(host)-1] //去除换行符
+		ss.logger().Debug("Ignored host:", host)
+		if !ss.containsIgnoredHost(host) {
+			ss.addIgnoredHost(host)
+		}
 	}
 }
 
@@ -106,38 +183,56 @@ func (ss *SiteSearcher) addIgnoredHost(host string) {
 	ss.ignoredHost = append(ss.ignoredHost, host)
 }
 
-func (ss *SiteSearcher) Search(name string) []string {
+// Search 同时在所有的搜索源中搜索name，返回第一个找到的小说的url
+// 找到以后取消其它搜索源的请求，等待它们都结束以后才返回
+func (ss *SiteSearcher) Search(ctx context.Context, name string) []string {
 	result := make([]string, 0)
-	downloader := NewDefaultDownloader()
-
-	ch := make(chan string, len(ss.items))
+	var downloader Downloader = NewDefaultDownloader()
+	maxRetries := MAX_RETRIES_COUNT
+	if ss.engine != nil {
+		downloader = ss.engine.downloader
+		maxRetries = ss.engine.maxRetries
+	}
+	logger := ss.logger()
 
-	for _, item := range ss.items {
+	items := ss.searchItems()
+	ch := make(chan string, len(items)) //有缓冲，没有被读取的结果也不会阻塞goroutine
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
 
+	for _, item := range items {
+		wg.Add(1)
 		go func(item *SearcherItem, ch chan string) {
+			defer wg.Done()
 			extracter := AutoSelectExtracter(item.host)
-			searchURL := ss.mkSearchURL(item, name)
-			searchContent, err := downloader.Download(searchURL, MAX_RETRIES_COUNT)
+			if extracter == nil {
+				ch <- "none"
+				return
+			}
+			searchURL, err := ss.mkSearchURL(item, name)
 			if err != nil {
+				logger.Errorf("Make search url of %q fail: %v", item.host, err)
 				ch <- "none"
+				return
 			}
-
+			searchContent, err := DownloadText(WithResourceKind(ctx, ResourceSearch), downloader, searchURL, maxRetries)
 			if err != nil {
 				ch <- "none"
 			} else if objURL, ok := extracter.ExtractObjURL(name, searchContent); ok {
 				objURL = tool.FixUrl(objURL, searchURL)
 				ch <- objURL
-				log.Debugf("search url: %s -> %s", searchURL, objURL)
+				logger.Debugf("search url: %s -> %s", searchURL, objURL)
 				return
 			} else {
 				ch <- "none"
 			}
 
-			log.Debugf("search url: %s -> none", searchURL)
+			logger.Debugf("search url: %s -> none", searchURL)
 		}(item, ch)
 	}
 
-	for i := 0; i < len(ss.items); i++ {
+	for i := 0; i < len(items); i++ {
 		objURL := <-ch
 		if objURL != "none" {
 			result = append(result, objURL)
@@ -145,24 +240,28 @@ func (ss *SiteSearcher) Search(name string) []string {
 		}
 	}
 
+	cancel()
+	wg.Wait()
 	return result
 }
 
-func (ss *SiteSearcher) mkSearchURL(item *SearcherItem, name string) string {
+func (ss *SiteSearcher) mkSearchURL(item *SearcherItem, name string) (string, error) {
 	var err error
 	if item.gbk {
 		name, err = tool.ConvertUTF8ToGBK(name)
-		CheckError(err)
+		if err != nil {
+			return "", NewParseError("Name", name, err)
+		}
 	}
 	if item.escape {
 		name = tool.EscapeString(name)
 	}
-	return fmt.Sprintf(item.fmtSearchString, name)
+	return fmt.Sprintf(item.fmtSearchString, name), nil
 }
 
 type NativeSearcher struct{}
 
-func (ss *NativeSearcher) Search(name string) (result []string) {
+func (ss *NativeSearcher) Search(ctx context.Context, name string) (result []string) {
 	return
 }
 
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// searchExtracter 搜索结果页的内容就是小说的url
+type searchExtracter struct {
+	lineExtracter
+}
+
+func (e *searchExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
+	return searchPage, len(searchPage) > 0
+}
+
+func TestSiteSearcherCancelsOtherSites(t *testing.T) {
+	RegisterExtracter(`search\.test`, &searchExtracter{})
+	downloader := newMapDownloader(map[string]string{
+		"http://fast.search.test/?q=test": "http://fast.search.test/book/1/",
+		"http://slow.search.test/?q=test": "http://slow.search.test/book/1/",
+	})
+	downloader.delay["http://slow.search.test/?q=test"] = 10 * time.Second
+	engine := newTestEngine(downloader, WithBaseDir(t.TempDir()))
+
+	parent := new(SiteSearcher)
+	parent.AddItem("http://fast.search.test/?q=%s", false, false, "fast.search.test")
+	parent.AddItem("http://slow.search.test/?q=%s", false, false, "slow.search.test")
+	searcher := newEngineSiteSearcher(parent, engine)
+
+	// 找到以后取消慢的搜索源，并且等待它结束，不会等到它下载完成
+	start := time.Now()
+	result := searcher.Search(context.Background(), "test")
+	if len(result) != 1 || result[0] != "http://fast.search.test/book/1/" {
+		t.Errorf("unexpected search result %v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the slow site was not cancelled, search took %v", elapsed)
+	}
+	if downloader.count["http://slow.search.test/?q=test"] != 1 {
+		t.Errorf("expected the slow site to be requested once")
+	}
+}
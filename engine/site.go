@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SiteSetting 是针对某个网站的下载设置，一般在sites.json的SiteSettingList中进行配置
+type SiteSetting struct {
+	RateLimit  RateLimit         //对该网站的请求频率限制
+	Headers    map[string]string //请求头模板，值中的{scheme}, {host}, {url}会被替换成请求的URL中对应的部分
+	UserAgents []string          //轮流使用的User-Agent，为空的时候使用下载器的User-Agent
+
+	Proxy              string //该网站使用的代理，为空的时候使用下载器的代理，PROXY_DIRECT表示不使用代理
+	InsecureSkipVerify bool   //是否不验证该网站的https证书
+}
+
+// expandHeader 将请求头模板中的占位符替换成u中对应的部分
+func expandHeader(value string, u *url.URL) string {
+	return strings.NewReplacer("{scheme}", u.Scheme, "{host}", u.Host, "{url}", u.String()).Replace(value)
+}
+
+type siteSettingItem struct {
+	hostPattern *regexp.Regexp
+	setting     SiteSetting
+}
+
+var (
+	siteSettingsMu     sync.RWMutex
+	globalSiteSettings []siteSettingItem //使用RegisterSiteSetting注册的设置
+	sitesSiteSettings  []siteSettingItem //使用ReplaceSites注册的设置，重新加载sites.json的时候整体替换
+)
+
+// RegisterSiteSetting 注册主机名称匹配regexpStr的网站的设置，按照注册的顺序进行匹配
+// 同一个regexpStr重复注册的时候，后注册的设置覆盖先前的设置
+func RegisterSiteSetting(regexpStr string, setting SiteSetting) error {
+	pattern, err := regexp.Compile(regexpStr)
+	if err != nil {
+		return NewParseError("HostPattern", regexpStr, err)
+	}
+
+	siteSettingsMu.Lock()
+	defer siteSettingsMu.Unlock()
+	for i := range globalSiteSettings {
+		if globalSiteSettings[i].hostPattern.String() == regexpStr {
+			globalSiteSettings[i].setting = setting
+			return nil
+		}
+	}
+	globalSiteSettings = append(globalSiteSettings, siteSettingItem{pattern, setting})
+	return nil
+}
+
+// LookupSiteSetting 返回host对应的网站设置，没有注册的时候ok为false
+// RegisterSiteSetting注册的设置优先于sites.json中的设置
+func LookupSiteSetting(host string) (setting SiteSetting, ok bool) {
+	siteSettingsMu.RLock()
+	defer siteSettingsMu.RUnlock()
+	for _, items := range [][]siteSettingItem{globalSiteSettings, sitesSiteSettings} {
+		for _, item := range items {
+			if item.hostPattern.MatchString(host) {
+				return item.setting, true
+			}
+		}
+	}
+	return
+}
+
+// hostOf 返回URL中的主机名称，解析失败的时候返回空字符串
+func hostOf(URL string) string {
+	if u, err := url.Parse(URL); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// HostSiteSetting 是主机名称匹配HostPattern的网站的设置
+type HostSiteSetting struct {
+	HostPattern string
+	Setting     SiteSetting
+}
+
+// Sites 是从sites.json中加载的Extracter，搜索源和网站设置，使用ReplaceSites整体替换
+type Sites struct {
+	Extracters   map[string]Extracter //主机名称正则表达式 -> Extracter
+	SearchItems  []*SearcherItem
+	SiteSettings []HostSiteSetting //按照顺序进行匹配
+}
+
+// replaceSitesMu 保证同一时间只有一个ReplaceSites
+var replaceSitesMu sync.Mutex
+
+// ReplaceSites 使用sites替换上一次ReplaceSites注册的内容，用来在不重新启动的情况下重新加载sites.json
+//
+// 正则表达式不合法的时候返回*ParseError，这时仍然使用原来的内容。替换是原子的，
+// 正在下载的小说继续使用开始的时候选择的Extracter，新的下载使用新的内容。
+// 使用RegisterExtracter, RegisterSiteSetting和GlobalSiteSearcher.AddItem注册的内容不会被替换
+func ReplaceSites(sites *Sites) error {
+	extracters := make(map[string]Extracter, len(sites.Extracters))
+	for pattern, extracter := range sites.Extracters {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return NewParseError("HostPattern", pattern, err)
+		}
+		extracters[pattern] = extracter
+	}
+	settings := make([]siteSettingItem, 0, len(sites.SiteSettings))
+	for _, s := range sites.SiteSettings {
+		pattern, err := regexp.Compile(s.HostPattern)
+		if err != nil {
+			return NewParseError("HostPattern", s.HostPattern, err)
+		}
+		settings = append(settings, siteSettingItem{pattern, s.Setting})
+	}
+	items := append([]*SearcherItem(nil), sites.SearchItems...)
+
+	replaceSitesMu.Lock()
+	defer replaceSitesMu.Unlock()
+
+	// 同时持有所有的锁，其它goroutine不会看到只替换了一部分的内容
+	extractersMu.Lock()
+	siteSettingsMu.Lock()
+	GlobalSiteSearcher.mu.Lock()
+	siteExtracters = extracters
+	sitesSiteSettings = settings
+	GlobalSiteSearcher.siteItems = items
+	GlobalSiteSearcher.mu.Unlock()
+	siteSettingsMu.Unlock()
+	extractersMu.Unlock()
+	return nil
+}
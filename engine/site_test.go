@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func hasSearchItem(host string) bool {
+	for _, item := range GlobalSiteSearcher.searchItems() {
+		if item.host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReplaceSites(t *testing.T) {
+	RegisterExtracter(`novel\.test`, &lineExtracter{})
+	defer ReplaceSites(&Sites{})
+
+	limit := RateLimit{RequestsPerSecond: 1, Burst: 1}
+	err := ReplaceSites(&Sites{
+		Extracters:   map[string]Extracter{`reload\.test`: &lineExtracter{}},
+		SearchItems:  []*SearcherItem{NewSearcherItem("http://reload.test/?q=%s", true, false, "reload.test")},
+		SiteSettings: []HostSiteSetting{{`^reload\.test$`, SiteSetting{RateLimit: limit}}},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceSites fail: %v", err)
+	}
+	if _, ok := AutoSelectExtracter("http://reload.test/").(*lineExtracter); !ok || !hasSearchItem("reload.test") {
+		t.Fatalf("sites are not registered")
+	}
+	downloader := NewRateLimitDownloader(newMapDownloader(nil), DEFAULT_RATE_LIMIT)
+	oldLimiter := downloader.limiter("reload.test")
+
+	// 替换的时候其它goroutine一直在选择Extracter
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					if AutoSelectExtracter("http://reload.test/") == nil || AutoSelectExtracter("http://novel.test/") == nil {
+						t.Errorf("extracter disappeared while replacing sites")
+						return
+					}
+					LookupSiteSetting("reload.test")
+				}
+			}
+		}()
+	}
+	limit.RequestsPerSecond = 2
+	err = ReplaceSites(&Sites{
+		Extracters:   map[string]Extracter{`reload\.test`: &replayExtracter{}},
+		SiteSettings: []HostSiteSetting{{`^reload\.test$`, SiteSetting{RateLimit: limit}}},
+	})
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("ReplaceSites fail: %v", err)
+	}
+	if _, ok := AutoSelectExtracter("http://reload.test/").(*replayExtracter); !ok || hasSearchItem("reload.test") {
+		t.Errorf("sites are not replaced")
+	}
+	if limiter := downloader.limiter("reload.test"); limiter == oldLimiter || limiter.limit.RequestsPerSecond != 2 {
+		t.Errorf("rate limit is not reloaded: %+v", limiter.limit)
+	}
+
+	// 不合法的配置被拒绝，继续使用原来的配置
+	var parseErr *ParseError
+	if err := ReplaceSites(&Sites{Extracters: map[string]Extracter{`(`: &lineExtracter{}}}); !errors.As(err, &parseErr) {
+		t.Errorf("expected a parse error, but got %v", err)
+	}
+	if _, ok := AutoSelectExtracter("http://reload.test/").(*replayExtracter); !ok {
+		t.Errorf("broken sites should not replace the working one")
+	}
+
+	// RegisterExtracter注册的Extracter不会被替换
+	ReplaceSites(&Sites{})
+	if AutoSelectExtracter("http://reload.test/") != nil || AutoSelectExtracter("http://novel.test/") == nil {
+		t.Errorf("unexpected extracters after replacing with empty sites")
+	}
+}
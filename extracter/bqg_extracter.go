@@ -7,7 +7,9 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/twoflyliu/novel/cleaner"
 	"github.com/twoflyliu/novel/engine"
 )
 
@@ -16,6 +18,7 @@ type BiqugeExtracter struct {
 }
 
 func NewExtracter(searchObjUrlPattern string) engine.Extracter {
+	compileBiqugePatternsOnce.Do(compileBiqugePatterns)
 	return &BiqugeExtracter{
 		searchObjUrlPattern: searchObjUrlPattern,
 	}
@@ -32,10 +35,6 @@ const (
 	MENUITEM_PATTERN_SUBMATCH                    = `\<a[\s\S]+?href="([\s\S]+?)"\s*\>([\s\S]+?)\</a\>`
 	CHAPTERTITLE_PATTERN_SUBMATCH                = `\<div\s+class="bookname"[\s\S]+?\<h1\>([\s\S]+?)\</h1\>`
 	CHAPTERCONTENT_PATTERN_SUBMATCH              = `\<div\s+id="content"\s*\>([\s\S]+?)\</div\>`
-	BR_PATTERN_REPLACE_NEWLINE                   = `\<br\s*/\>`
-	ESCAPE_PATTERN_REMOVE                        = `&[\s\S]+?;`
-	DIV_PATTERN_REMOVE                           = `\<div[\s\S]+?\</div\>`
-	SCRIPT_PATTERN_REMOVE                        = `\<script[\s\S]+?\</script\>`
 	CHINESE_SEC_STR                              = "："      //中文分号字符
 	CHINESE_SEC_LEN                              = len("：") //中文分号长度
 
@@ -63,10 +62,7 @@ var (
 	menuItemPatternSubMatch                   *regexp.Regexp
 	chapterTitlePatternSubMatch               *regexp.Regexp
 	chapterContentPatternSubMatch             *regexp.Regexp
-	brPatternReplaceNewLine                   *regexp.Regexp
-	escapePatternRemove                       *regexp.Regexp
-	divPatternRemove                          *regexp.Regexp
-	scriptPatternRemove                       *regexp.Regexp
+	chapterContentClean                       *cleaner.Pipeline
 
 	bqgSearchFormFind                 *regexp.Regexp
 	bqgSearchFormActionMethodSubmatch *regexp.Regexp
@@ -124,7 +120,7 @@ func (extracter *BiqugeExtracter) ExtractMenuList(fullPage string) (result [][]s
 		}
 
 	}
-	return
+	return removeRepeatedMenuItems(result)
 }
 
 // 从fullPage从提取出小说标题
@@ -140,19 +136,14 @@ func (extracter *BiqugeExtracter) ExtractChapterTitle(fullPage string) (chapterT
 func (extracter *BiqugeExtracter) ExtractChapterContent(fullPage string) (content string) {
 	matches := chapterContentPatternSubMatch.FindStringSubmatch(fullPage)
 	if len(matches) > 1 {
-		content = matches[1]
-		content = brPatternReplaceNewLine.ReplaceAllString(content, "\n") //替换<br/>为换行
-		content = escapePatternRemove.ReplaceAllString(content, "")       //删除所有的形如&lt;&gt;的转义内容
-		content = divPatternRemove.ReplaceAllString(content, "")          //删除所有的内容中的div元素标签
-		content = scriptPatternRemove.ReplaceAllString(content, "")       //删除所有内容中的script元素标签
-		content = strings.Replace(content, "nbsp;", " ", -1)              //替换掉转义字符
+		content = chapterContentClean.Clean(matches[1])
 	}
 	return
 }
 
 // 从url中提取出目录的url
 func (extracter *BiqugeExtracter) ExtractMenuURL(url string) (menuURL string) {
-	if url[len(url)-4:] != "html" {
+	if !strings.HasSuffix(url, "html") {
 		menuURL = url
 	} else {
 		pos := strings.LastIndex(url, "/")
@@ -170,15 +161,16 @@ func (extracter *BiqugeExtracter) ExtractNewestLastChapterName(fullPage string)
 }
 
 func (extracter *BiqugeExtracter) extractFormString(fullPage string) string {
-	fmt.Print(fullPage)
+	log.Debugf("extract search form from page, len(fullPage): %d", len(fullPage))
 	formString := bqgSearchFormFind.FindString(fullPage)
 	return formString
 }
 
 // 从fullPage中提取出所有的搜索表单中的隐藏字段和值
 func (extracter *BiqugeExtracter) ExtractSearchFormHiddenValues(fullPage string) (values url.Values) {
+	values = make(url.Values)
 	formString := extracter.extractFormString(fullPage)
-	fmt.Println("len(formString):", len(formString))
+	log.Debug("len(formString):", len(formString))
 	matches := bqgSearchFormHiddenValueSubmatch.FindAllStringSubmatch(formString, -1)
 	for _, submatch := range matches {
 		if len(submatch) > 2 {
@@ -209,8 +201,12 @@ func (extracter *BiqugeExtracter) ExtractSearchFormSearchFieldName(fullPage stri
 }
 
 func (extracter *BiqugeExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
-	fullSearchString := fmt.Sprintf(extracter.searchObjUrlPattern, name)
-	searchObjUrlPattern := regexp.MustCompile(fullSearchString)
+	fullSearchString := fmt.Sprintf(extracter.searchObjUrlPattern, regexp.QuoteMeta(name))
+	searchObjUrlPattern, err := regexp.Compile(fullSearchString)
+	if err != nil {
+		log.Errorf("Compile search object url pattern %q fail: %v", fullSearchString, err)
+		return "", false
+	}
 	matches := searchObjUrlPattern.FindStringSubmatch(searchPage)
 
 	//fmt.Printf("pattern: %s", searchObjUrlPattern)
@@ -245,7 +241,7 @@ func (extracter *BiqugeExtracter) ExtractNovelDescription(menuPage string) (desc
 	description = strings.TrimSpace(description)
 
 	if left := strings.Index(description, "<p>"); left != -1 {
-		if right := strings.Index(description, "</p>"); right != -1 {
+		if right := strings.Index(description, "</p>"); right > left {
 			description = description[left+3 : right]
 			description = strings.TrimSpace(description)
 		}
@@ -254,6 +250,28 @@ func (extracter *BiqugeExtracter) ExtractNovelDescription(menuPage string) (desc
 	return
 }
 
+var compileBiqugePatternsOnce sync.Once
+
+// compileBiqugePatterns 编译所有BiqugeExtracter共用的正则表达式，它们都是常量，不会出错
+func compileBiqugePatterns() {
+	novelNamePatternSubMatch = regexp.MustCompile(NOVEL_NAME_PATTERN_SUBMATCH)
+	novelAuthorPatternSubMatch = regexp.MustCompile(NOVEL_AUTHOR_PATTERN_SUBMATCH)
+	novelLastUpdateTimePatternSubMatch = regexp.MustCompile(NOVEL_LASTUPDATETIME_PATTERN_SUBMATCH)
+	novelDescriptionPatternSubMatch = regexp.MustCompile(NOVEL_DESCRIPTION_PATTERN_SUBMATCH)
+	novelIconUrlPatternSubMatch = regexp.MustCompile(NOVEL_ICON_URL_PATTERN_SUBMATCH)
+	novelNewestLastChapterNamePatternSubMatch = regexp.MustCompile(NOVEL_NEWESTLASTCHAPTERNAME_PATTERN_SUBMATCH)
+	menuListPatternFind = regexp.MustCompile(MENULIST_PATTERN_FIND)
+	menuItemPatternSubMatch = regexp.MustCompile(MENUITEM_PATTERN_SUBMATCH)
+	chapterTitlePatternSubMatch = regexp.MustCompile(CHAPTERTITLE_PATTERN_SUBMATCH)
+	chapterContentPatternSubMatch = regexp.MustCompile(CHAPTERCONTENT_PATTERN_SUBMATCH)
+	chapterContentClean = cleaner.Default()
+
+	bqgSearchFormFind = regexp.MustCompile(BQG_SEARCH_FORM_FIND)
+	bqgSearchFormActionMethodSubmatch = regexp.MustCompile(BQG_SEARCH_FORM_ACTION_METHOD_SUBMATCH)
+	bqgSearchFormHiddenValueSubmatch = regexp.MustCompile(BQG_SEARCH_FORM_HIDDEN_VALUE_SUBMATCH)
+	bqgSearchFormNameFieldSubmatch = regexp.MustCompile(BQG_SEARCH_FORM_NAME_FIELD_SUBMATCH)
+}
+
 // 使用一种自注册技术
 func init() {
 	/*
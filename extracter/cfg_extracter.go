@@ -1,14 +1,15 @@
 package common //包名称和目录名称未必要一样
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"html"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/twoflyliu/novel/cleaner"
 	"github.com/twoflyliu/novel/engine"
 )
 
@@ -23,15 +24,40 @@ type ExtracterPattern struct {
 	MenuItemPattern                  string
 	ChapterTitlePattern              string
 	ChapterContentPattern            string
-	BrElementPattern                 string
-	EscapeElementPattern             string
-	DivElementPattern                string
-	ScriptElementPattern             string
+	BrElementPattern                 string //已经废弃，使用Clean
+	EscapeElementPattern             string //已经废弃，使用Clean
+	DivElementPattern                string //已经废弃，使用Clean
+	ScriptElementPattern             string //已经废弃，使用Clean
 	SearchFormPattern                string
 	SearchFormMethodAttributePattern string
 	SearchFormHiddenFieldPattern     string
 	SearchFormShowFieldPattern       string
 	SearchObjUrlPattern              string
+	ChapterNextPagePattern           string         //可选，章节分成多页的时候匹配下一页的url
+	MenuNextPagePattern              string         //可选，目录分成多页的时候匹配下一页的url
+	Clean                            []cleaner.Step //依次清理章节内容的步骤
+}
+
+// CleanSteps 返回清理章节内容的步骤
+// 没有设置Clean的时候将旧的BrElementPattern等设置转换成同样效果的步骤，旧的sites.json提取的结果不变，
+// 这些也没有设置的时候使用cleaner.DefaultSteps()
+func (p *ExtracterPattern) CleanSteps() []cleaner.Step {
+	if len(p.Clean) > 0 {
+		return p.Clean
+	}
+	if len(p.BrElementPattern)+len(p.EscapeElementPattern)+len(p.DivElementPattern)+len(p.ScriptElementPattern) == 0 {
+		return cleaner.DefaultSteps()
+	}
+	steps := make([]cleaner.Step, 0, 5)
+	if len(p.BrElementPattern) > 0 {
+		steps = append(steps, cleaner.Step{Type: cleaner.StepReplace, Pattern: p.BrElementPattern, Replace: "\n"})
+	}
+	for _, pattern := range []string{p.EscapeElementPattern, p.DivElementPattern, p.ScriptElementPattern} {
+		if len(pattern) > 0 {
+			steps = append(steps, cleaner.Step{Type: cleaner.StepRemove, Pattern: pattern})
+		}
+	}
+	return append(steps, cleaner.Step{Type: cleaner.StepReplace, Pattern: "nbsp;", Replace: " "})
 }
 
 type RegistrySearch struct {
@@ -46,10 +72,53 @@ type RegistryExtracter struct {
 	ExtracterRef string
 }
 
+// 对某些网站的下载设置，延迟使用"500ms"这样的字符串
+// Headers的值中可以使用{scheme}, {host}, {url}，比如"Referer": "{scheme}://{host}/"
+type RegistrySiteSetting struct {
+	HostPattern        string
+	RequestsPerSecond  float64
+	Burst              int
+	MaxConcurrent      int
+	MinDelay           string
+	MaxDelay           string
+	Headers            map[string]string
+	UserAgents         []string
+	Proxy              string //http://, https://, socks5://或者direct
+	InsecureSkipVerify bool
+}
+
 type SitesConfig struct {
 	ExtracterMap          map[string]ExtracterPattern
+	SelectorExtracterMap  map[string]SelectorExtracterPattern //使用CSS选择器的Extracter，名称不能和ExtracterMap中的重复
 	RegistrySearchList    []RegistrySearch
 	RegistryExtracterList []RegistryExtracter
+	SiteSettingList       []RegistrySiteSetting
+}
+
+func (s *RegistrySiteSetting) siteSetting() (setting engine.SiteSetting, err error) {
+	setting.RateLimit = engine.RateLimit{
+		RequestsPerSecond: s.RequestsPerSecond,
+		Burst:             s.Burst,
+		MaxConcurrent:     s.MaxConcurrent,
+	}
+	setting.Headers = s.Headers
+	setting.UserAgents = s.UserAgents
+	setting.Proxy = s.Proxy
+	setting.InsecureSkipVerify = s.InsecureSkipVerify
+	if len(s.Proxy) > 0 && s.Proxy != engine.PROXY_DIRECT {
+		if _, err = engine.ParseProxy(s.Proxy); err != nil {
+			return
+		}
+	}
+	if len(s.MinDelay) > 0 {
+		if setting.RateLimit.MinDelay, err = time.ParseDuration(s.MinDelay); err != nil {
+			return
+		}
+	}
+	if len(s.MaxDelay) > 0 {
+		setting.RateLimit.MaxDelay, err = time.ParseDuration(s.MaxDelay)
+	}
+	return
 }
 
 type ConfigExtracter struct {
@@ -63,17 +132,18 @@ type ConfigExtracter struct {
 	menuItemPatternSubMatch                   *regexp.Regexp
 	chapterTitlePatternSubMatch               *regexp.Regexp
 	chapterContentPatternSubMatch             *regexp.Regexp
-	brPatternReplaceNewLine                   *regexp.Regexp
-	escapePatternRemove                       *regexp.Regexp
-	divPatternRemove                          *regexp.Regexp
-	scriptPatternRemove                       *regexp.Regexp
+	clean                                     *cleaner.Pipeline
 	searchFormFind                            *regexp.Regexp
 	searchFormActionMethodSubmatch            *regexp.Regexp
 	searchFormHiddenValueSubmatch             *regexp.Regexp
 	searchFormNameFieldSubmatch               *regexp.Regexp
 	searchObjUrlPattern                       string
+	chapterNextPagePattern                    *regexp.Regexp //为nil表示章节只有一页
+	menuNextPagePattern                       *regexp.Regexp //为nil表示目录只有一页
 }
 
+// NewConfigExtracter 使用sites.json中的正则表达式创建Extracter
+// 正则表达式不合法的时候返回*engine.ParseError
 func NewConfigExtracter(extracterName string,
 	novelNamePattern string,
 	novelAuthorPattern string,
@@ -85,53 +155,67 @@ func NewConfigExtracter(extracterName string,
 	menuItemPattern string,
 	chapterTitle string,
 	chapterContentPattern string,
-	brElementPattern string,
-	escapeElementPattern string,
-	divElementPattern string,
-	scriptElementPattern string,
+	clean []cleaner.Step,
 	searchFormPattern string,
 	searchFormMethodAttributePattern string,
 	searchFormHiddenFieldPattern string,
 	searchFormShowFieldPattern string,
-	searchObjUrlPattern string) *ConfigExtracter {
+	searchObjUrlPattern string,
+	chapterNextPagePattern string,
+	menuNextPagePattern string) (*ConfigExtracter, error) {
 	var e ConfigExtracter
+	var err error
 
-	e.novelNamePatternSubMatch = mustCompilePattern(extracterName, "NovelNamePattern", novelNamePattern)
-	e.novelAuthorPatternSubMatch = mustCompilePattern(extracterName, "NovelAuthorPattern", novelAuthorPattern)
-	e.novelIconUrlPatternSubMatch = mustCompilePattern(extracterName, "NovelIconUrlPattern", novelIconUrlPattern)
-	e.novelLastUpdateTimePatternSubMatch = mustCompilePattern(extracterName, "NovelLastUpdateTimePattern", novelLastUpdateTimePattern)
-	e.novelNewestLastChapterNamePatternSubMatch = mustCompilePattern(extracterName, "NovelNewestChapterNamePattern", novelNewestChapterNamePattern)
-	e.novelDescriptionPatternSubMatch = mustCompilePattern(extracterName, "NovelDescriptionPattern", novelDescriptionPattern)
-	e.menuListPatternFind = mustCompilePattern(extracterName, "MenuListPattern", menuListPattern)
-	e.menuItemPatternSubMatch = mustCompilePattern(extracterName, "MenuItemPattern", menuItemPattern)
-	e.chapterTitlePatternSubMatch = mustCompilePattern(extracterName, "ChapterTitlePattern", chapterTitle)
-	e.chapterContentPatternSubMatch = mustCompilePattern(extracterName, "ChapterContentPattern", chapterContentPattern)
-	e.brPatternReplaceNewLine = mustCompilePattern(extracterName, "BrElementPattern", brElementPattern)
-	e.escapePatternRemove = mustCompilePattern(extracterName, "EscapeElementPattern", escapeElementPattern)
-	e.divPatternRemove = mustCompilePattern(extracterName, "DivElementPattern", divElementPattern)
-	e.scriptPatternRemove = mustCompilePattern(extracterName, "ScriptElementPattern", scriptElementPattern)
-	e.searchFormFind = mustCompilePattern(extracterName, "SearchFormPattern", searchFormPattern)
-	e.searchFormActionMethodSubmatch = mustCompilePattern(extracterName, "SearchFormMethodAttributePattern", searchFormMethodAttributePattern)
-	e.searchFormHiddenValueSubmatch = mustCompilePattern(extracterName, "SearchFormHiddenFieldPattern", searchFormHiddenFieldPattern)
-	e.searchFormNameFieldSubmatch = mustCompilePattern(extracterName, "SearchFormShowFieldPattern", searchFormShowFieldPattern)
+	// 只记录第一个错误，出错以后不再编译剩下的正则表达式
+	compile := func(patternName string, patternStr string) *regexp.Regexp {
+		if err != nil {
+			return nil
+		}
+		var pattern *regexp.Regexp
+		pattern, err = compilePattern(extracterName, patternName, patternStr)
+		return pattern
+	}
 
-	if searchObjUrlPattern == "" {
-		fmt.Fprintf(os.Stderr, "%q.%q cannot be empty\n", extracterName, "SearchObjUrlPattern")
-		os.Exit(1)
-	} else {
-		e.searchObjUrlPattern = searchObjUrlPattern
+	e.novelNamePatternSubMatch = compile("NovelNamePattern", novelNamePattern)
+	e.novelAuthorPatternSubMatch = compile("NovelAuthorPattern", novelAuthorPattern)
+	e.novelIconUrlPatternSubMatch = compile("NovelIconUrlPattern", novelIconUrlPattern)
+	e.novelLastUpdateTimePatternSubMatch = compile("NovelLastUpdateTimePattern", novelLastUpdateTimePattern)
+	e.novelNewestLastChapterNamePatternSubMatch = compile("NovelNewestChapterNamePattern", novelNewestChapterNamePattern)
+	e.novelDescriptionPatternSubMatch = compile("NovelDescriptionPattern", novelDescriptionPattern)
+	e.menuListPatternFind = compile("MenuListPattern", menuListPattern)
+	e.menuItemPatternSubMatch = compile("MenuItemPattern", menuItemPattern)
+	e.chapterTitlePatternSubMatch = compile("ChapterTitlePattern", chapterTitle)
+	e.chapterContentPatternSubMatch = compile("ChapterContentPattern", chapterContentPattern)
+	e.searchFormFind = compile("SearchFormPattern", searchFormPattern)
+	e.searchFormActionMethodSubmatch = compile("SearchFormMethodAttributePattern", searchFormMethodAttributePattern)
+	e.searchFormHiddenValueSubmatch = compile("SearchFormHiddenFieldPattern", searchFormHiddenFieldPattern)
+	e.searchFormNameFieldSubmatch = compile("SearchFormShowFieldPattern", searchFormShowFieldPattern)
+	if len(chapterNextPagePattern) > 0 {
+		e.chapterNextPagePattern = compile("ChapterNextPagePattern", chapterNextPagePattern)
+	}
+	if len(menuNextPagePattern) > 0 {
+		e.menuNextPagePattern = compile("MenuNextPagePattern", menuNextPagePattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.clean, err = cleaner.New(extracterName+".Clean", clean); err != nil {
+		return nil, err
 	}
 
-	return &e
+	if searchObjUrlPattern == "" {
+		return nil, engine.NewParseError(extracterName+".SearchObjUrlPattern", searchObjUrlPattern, errors.New("cannot be empty"))
+	}
+	e.searchObjUrlPattern = searchObjUrlPattern
+	return &e, nil
 }
 
-func mustCompilePattern(extracterName string, patternName string, patternStr string) *regexp.Regexp {
+func compilePattern(extracterName string, patternName string, patternStr string) (*regexp.Regexp, error) {
 	pattern, err := regexp.Compile(patternStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Comple %q.%q=%q failed:%s\n", extracterName, patternName, patternStr, err.Error())
-		os.Exit(1)
+		return nil, engine.NewParseError(extracterName+"."+patternName, patternStr, err)
 	}
-	return pattern
+	return pattern, nil
 }
 
 const (
@@ -193,10 +277,14 @@ func (e *ConfigExtracter) ExtractMenuList(fullPage string) (finalResult [][]stri
 
 	}
 
-	fmt.Printf("before filter menu item count: %d\n", len(result))
-	// 移除前置重复章节(类似笔趣阁缓存章节)
+	return removeRepeatedMenuItems(result)
+}
+
+// removeRepeatedMenuItems 移除前置重复章节(类似笔趣阁缓存章节)，保留最后一次出现的章节
+func removeRepeatedMenuItems(result [][]string) (finalResult [][]string) {
+	log.Debugf("before filter menu item count: %d", len(result))
 	finalResult = make([][]string, 0)
-	for i := 0; i < len(result)-1; i++ {
+	for i := 0; i < len(result); i++ {
 		repeat := false
 		for j := i + 1; j < len(result); j++ {
 			if result[i][1] == result[j][1] {
@@ -209,7 +297,7 @@ func (e *ConfigExtracter) ExtractMenuList(fullPage string) (finalResult [][]stri
 			finalResult = append(finalResult, result[i])
 		}
 	}
-	fmt.Printf("after filter menu item count: %d\n", len(finalResult))
+	log.Debugf("after filter menu item count: %d", len(finalResult))
 
 	return
 }
@@ -227,19 +315,19 @@ func (e *ConfigExtracter) ExtractChapterTitle(fullPage string) (chapterTitle str
 func (e *ConfigExtracter) ExtractChapterContent(fullPage string) (content string) {
 	matches := e.chapterContentPatternSubMatch.FindStringSubmatch(fullPage)
 	if len(matches) > 1 {
-		content = matches[1]
-		content = e.brPatternReplaceNewLine.ReplaceAllString(content, "\n") //替换<br/>为换行
-		content = e.escapePatternRemove.ReplaceAllString(content, "")       //删除所有的形如&lt;&gt;的转义内容
-		content = e.divPatternRemove.ReplaceAllString(content, "")          //删除所有的内容中的div元素标签
-		content = e.scriptPatternRemove.ReplaceAllString(content, "")       //删除所有内容中的script元素标签
-		content = strings.Replace(content, "nbsp;", " ", -1)                //替换掉转义字符
+		content = e.clean.Clean(matches[1])
 	}
 	return
 }
 
 // 从url中提取出目录的url
 func (extracter *ConfigExtracter) ExtractMenuURL(url string) (menuURL string) {
-	if url[len(url)-4:] != "html" {
+	return menuURLOf(url)
+}
+
+// menuURLOf 章节页的url去掉最后的文件名就是目录页的url
+func menuURLOf(url string) (menuURL string) {
+	if !strings.HasSuffix(url, "html") {
 		menuURL = url
 	} else {
 		pos := strings.LastIndex(url, "/")
@@ -257,15 +345,16 @@ func (e *ConfigExtracter) ExtractNewestLastChapterName(fullPage string) (newestL
 }
 
 func (e *ConfigExtracter) extractFormString(fullPage string) string {
-	fmt.Print(fullPage)
+	log.Debugf("extract search form from page, len(fullPage): %d", len(fullPage))
 	formString := e.searchFormFind.FindString(fullPage)
 	return formString
 }
 
 // 从fullPage中提取出所有的搜索表单中的隐藏字段和值
 func (e *ConfigExtracter) ExtractSearchFormHiddenValues(fullPage string) (values url.Values) {
+	values = make(url.Values)
 	formString := e.extractFormString(fullPage)
-	fmt.Println("len(formString):", len(formString))
+	log.Debug("len(formString):", len(formString))
 	matches := e.searchFormHiddenValueSubmatch.FindAllStringSubmatch(formString, -1)
 	for _, submatch := range matches {
 		if len(submatch) > 2 {
@@ -296,8 +385,12 @@ func (e *ConfigExtracter) ExtractSearchFormSearchFieldName(fullPage string) (sea
 }
 
 func (e *ConfigExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
-	fullSearchString := fmt.Sprintf(e.searchObjUrlPattern, name)
-	searchObjUrlPattern := regexp.MustCompile(fullSearchString)
+	fullSearchString := fmt.Sprintf(e.searchObjUrlPattern, regexp.QuoteMeta(name))
+	searchObjUrlPattern, err := regexp.Compile(fullSearchString)
+	if err != nil {
+		log.Errorf("Compile search object url pattern %q fail: %v", fullSearchString, err)
+		return "", false
+	}
 	matches := searchObjUrlPattern.FindStringSubmatch(searchPage)
 
 	//fmt.Printf("pattern: %s", searchObjUrlPattern)
@@ -332,7 +425,7 @@ func (e *ConfigExtracter) ExtractNovelDescription(menuPage string) (description
 	description = strings.TrimSpace(description)
 
 	if left := strings.Index(description, "<p>"); left != -1 {
-		if right := strings.Index(description, "</p>"); right != -1 {
+		if right := strings.Index(description, "</p>"); right > left {
 			description = description[left+3 : right]
 			description = strings.TrimSpace(description)
 		}
@@ -341,59 +434,22 @@ func (e *ConfigExtracter) ExtractNovelDescription(menuPage string) (description
 	return
 }
 
-// 使用一种自注册技术
-func init() {
-	// 加载本地的site.config
-	var siteConfig SitesConfig
-
-	fullpath := "./sites.json"
-	file, err := os.Open(fullpath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "./sites.json not exist\n")
-		os.Exit(2)
-	}
-	defer file.Close()
+// 从fullPage中提取出章节下一页的url，没有设置ChapterNextPagePattern的时候返回空字符串
+func (e *ConfigExtracter) ExtractChapterNextPage(fullPage string) string {
+	return extractNextPage(e.chapterNextPagePattern, fullPage)
+}
 
-	bytes, err := ioutil.ReadAll(file)
-	err = json.Unmarshal(bytes, &siteConfig)
+// 从fullPage中提取出目录下一页的url，没有设置MenuNextPagePattern的时候返回空字符串
+func (e *ConfigExtracter) ExtractMenuNextPage(fullPage string) string {
+	return extractNextPage(e.menuNextPagePattern, fullPage)
+}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "sites.json corrupt!\n")
-		os.Exit(2)
-	}
-
-	extracterMap := make(map[string]*ConfigExtracter)
-
-	for n, e := range siteConfig.ExtracterMap {
-		extracterMap[n] = NewConfigExtracter(
-			n,
-			e.NovelNamePattern,
-			e.NovelAuhtorPattern,
-			e.NovelIconUrlPattern,
-			e.NovelLastUpdateTimePattern,
-			e.NovelNewestChapterNamePattern,
-			e.NovelDescriptionPattern,
-			e.MenuListPattern,
-			e.MenuItemPattern,
-			e.ChapterTitlePattern,
-			e.ChapterContentPattern,
-			e.BrElementPattern,
-			e.EscapeElementPattern,
-			e.DivElementPattern,
-			e.ScriptElementPattern,
-			e.SearchFormPattern,
-			e.SearchFormMethodAttributePattern,
-			e.SearchFormHiddenFieldPattern,
-			e.SearchFormShowFieldPattern,
-			e.SearchObjUrlPattern,
-		)
-	}
-
-	for _, s := range siteConfig.RegistrySearchList {
-		engine.GlobalSiteSearcher.AddItem(s.SearchUrlFmtStr, s.NeedEscape, s.GBKEncoding, s.Host)
-	}
-
-	for _, e := range siteConfig.RegistryExtracterList {
-		engine.RegisterExtracter(e.HostPattern, extracterMap[e.ExtracterRef])
+func extractNextPage(pattern *regexp.Regexp, fullPage string) string {
+	if pattern == nil {
+		return ""
+	}
+	if submatch := pattern.FindStringSubmatch(fullPage); len(submatch) > 1 {
+		return html.UnescapeString(submatch[1]) //href中的&amp;
 	}
+	return ""
 }
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 目录开头重复的最新章节被去掉，最后一个章节保留
+// 原来的循环没有检查最后一个章节，每本小说都少了最新的一章
+func TestExtractMenuListKeepsLastItem(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatalf("load default sites fail: %v", err)
+	}
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("create extracters fail: %v", err)
+	}
+	extracters["BiqugeExtracter"] = NewExtracter(SEARCH_OBJ_URL_PATTERN_37ZW_STR)
+
+	tests := []struct {
+		name     string
+		items    string
+		expected [][]string
+	}{
+		{"unique", `<a href="1.html">第1章</a><a href="2.html">第2章</a><a href="3.html">第3章</a>`,
+			[][]string{{"1.html", "第1章"}, {"2.html", "第2章"}, {"3.html", "第3章"}}},
+		// 开头缓存的最新章节和最后一个章节重复，保留最后一个
+		{"repeated last", `<a href="3.html">第3章</a><a href="1.html">第1章</a><a href="2.html">第2章</a><a href="3.html">第3章</a>`,
+			[][]string{{"1.html", "第1章"}, {"2.html", "第2章"}, {"3.html", "第3章"}}},
+		{"single", `<a href="1.html">第1章</a>`, [][]string{{"1.html", "第1章"}}},
+	}
+	for _, name := range []string{"BQGExtracter", "BiqugeExtracter"} {
+		for _, test := range tests {
+			page := `<div id="list">` + test.items + `</div>`
+			if actual := extracters[name].ExtractMenuList(page); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("%s %s: expected %v, but got %v", name, test.name, test.expected, actual)
+			}
+		}
+	}
+}
+
+// </p>在<p>前面的时候不能panic，保留原来的简介
+func TestExtractNovelDescription(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatalf("load default sites fail: %v", err)
+	}
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("create extracters fail: %v", err)
+	}
+	extracters["BiqugeExtracter"] = NewExtracter(SEARCH_OBJ_URL_PATTERN_37ZW_STR)
+
+	tests := []struct {
+		intro    string
+		expected string
+	}{
+		{"<p> 一本小说。</p>", "一本小说。"},
+		{"上一部</p>&nbsp;<p>一本小说。", "上一部</p> <p>一本小说。"},
+		{"没有段落", "没有段落"},
+	}
+	for _, name := range []string{"BQGExtracter", "BiqugeExtracter"} {
+		for _, test := range tests {
+			page := `<div id="intro">` + test.intro + `</div>`
+			if actual := extracters[name].ExtractNovelDescription(page); actual != test.expected {
+				t.Errorf("%s: expected %q, but got %q", name, test.expected, actual)
+			}
+		}
+	}
+}
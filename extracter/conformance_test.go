@@ -0,0 +1,247 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+// 修改sites.json以后，使用下面的命令检查所有的Extracter，确认修改以后的结果正确再重新生成golden文件
+//
+//	go test ./extracter -run TestExtracterConformance
+//	go test ./extracter -run TestExtracterConformance -update
+//	go test ./extracter -run TestExtracterConformance -args -sites=/path/to/sites.json
+var (
+	updateGolden    = flag.Bool("update", false, "rewrite the golden files of TestExtracterConformance")
+	conformanceSite = flag.String("sites", "", "check the extracters of this sites.json instead of the embedded one")
+)
+
+const (
+	conformanceDir      = "testdata/conformance"
+	conformanceManifest = "fixtures.json" //每个目录中的页面由哪些Extracter检查
+	goldenSuffix        = ".golden.json"
+	diffContext         = 2 //diff中显示的变化前后相同的行数
+)
+
+// conformancePages 是每个目录中保存的页面
+var conformancePages = []string{"index.html", "menu.html", "chapter.html", "search.html"}
+
+// conformanceFixture 是fixtures.json中的一项
+type conformanceFixture struct {
+	Extracters []string
+	ChapterURL string //ExtractMenuURL的参数
+	SearchName string //ExtractObjURL在search.html中查找的小说
+}
+
+// conformanceResult 是Extracter所有方法的结果，顺序和engine.Extracter相同
+type conformanceResult struct {
+	NovelName                 string
+	LastUpdateTime            string
+	NovelAuthor               string
+	MenuList                  [][]string
+	NovelDescription          string
+	IconURL                   string
+	ChapterTitle              string
+	ChapterContent            string
+	MenuURL                   string
+	NewestLastChapterName     string
+	SearchFormHiddenValues    url.Values
+	SearchFormMethod          string
+	SearchFormAction          string
+	SearchFormSearchFieldName string
+	ObjURL                    string
+	ObjFound                  bool
+	ChapterNextPage           string
+	MenuNextPage              string
+}
+
+func runExtracter(e engine.Extracter, fixture *conformanceFixture, pages map[string]string) *conformanceResult {
+	menu, chapter, index := pages["menu.html"], pages["chapter.html"], pages["index.html"]
+	r := &conformanceResult{
+		NovelName:                 e.ExtractNovelName(menu),
+		LastUpdateTime:            e.ExtractLastUpdateTime(menu),
+		NovelAuthor:               e.ExtractNovelAuthor(menu),
+		MenuList:                  e.ExtractMenuList(menu),
+		NovelDescription:          e.ExtractNovelDescription(menu),
+		IconURL:                   e.ExtractIconURL(menu),
+		ChapterTitle:              e.ExtractChapterTitle(chapter),
+		ChapterContent:            e.ExtractChapterContent(chapter),
+		MenuURL:                   e.ExtractMenuURL(fixture.ChapterURL),
+		NewestLastChapterName:     e.ExtractNewestLastChapterName(menu),
+		SearchFormHiddenValues:    e.ExtractSearchFormHiddenValues(index),
+		SearchFormSearchFieldName: e.ExtractSearchFormSearchFieldName(index),
+	}
+	r.SearchFormMethod, r.SearchFormAction = e.ExtractSearchFormMethodAndAction(index)
+	r.ObjURL, r.ObjFound = e.ExtractObjURL(fixture.SearchName, pages["search.html"])
+	if pager, ok := e.(engine.ChapterPager); ok {
+		r.ChapterNextPage = pager.ExtractChapterNextPage(chapter)
+	}
+	if pager, ok := e.(engine.MenuPager); ok {
+		r.MenuNextPage = pager.ExtractMenuNextPage(menu)
+	}
+	return r
+}
+
+// marshalResult 返回缩进的json，不转义html中的字符，这样diff更容易阅读
+func marshalResult(r *conformanceResult) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err := encoder.Encode(r)
+	return buf.Bytes(), err
+}
+
+// conformanceExtracters 返回需要检查的所有Extracter，包括sites.json中的和BiqugeExtracter
+func conformanceExtracters(t *testing.T) map[string]engine.Extracter {
+	var config *SitesConfig
+	var err error
+	if len(*conformanceSite) > 0 {
+		config, err = LoadSitesConfig(*conformanceSite)
+	} else {
+		config, err = DefaultSitesConfig()
+	}
+	if err != nil {
+		t.Fatalf("load sites config fail: %v", err)
+	}
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("create extracters fail: %v", err)
+	}
+	extracters["BiqugeExtracter"] = NewExtracter(SEARCH_OBJ_URL_PATTERN_37ZW_STR)
+	return extracters
+}
+
+func TestExtracterConformance(t *testing.T) {
+	extracters := conformanceExtracters(t)
+
+	data, err := os.ReadFile(filepath.Join(conformanceDir, conformanceManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := make(map[string]*conformanceFixture)
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("parse %s fail: %v", conformanceManifest, err)
+	}
+	dirs := make([]string, 0, len(fixtures))
+	for dir := range fixtures {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	checked := make(map[string]bool)
+	for _, dir := range dirs {
+		fixture := fixtures[dir]
+		pages := make(map[string]string)
+		for _, name := range conformancePages {
+			page, err := os.ReadFile(filepath.Join(conformanceDir, dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages[name] = string(page)
+		}
+
+		for _, name := range fixture.Extracters {
+			checked[name] = true
+			t.Run(dir+"/"+name, func(t *testing.T) {
+				e, ok := extracters[name]
+				if !ok {
+					t.Fatalf("extracter %s is not defined", name)
+				}
+				actual, err := marshalResult(runExtracter(e, fixture, pages))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				goldenPath := filepath.Join(conformanceDir, dir, name+goldenSuffix)
+				if *updateGolden {
+					if err := os.WriteFile(goldenPath, actual, 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				expected, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("%v, run with -update to create it", err)
+				}
+				if !bytes.Equal(expected, actual) {
+					t.Errorf("result differs from %s (-golden +actual):\n%s", goldenPath, lineDiff(string(expected), string(actual)))
+				}
+			})
+		}
+	}
+
+	// 新增的Extracter也需要保存页面，并且加到fixtures.json中
+	for name := range extracters {
+		if !checked[name] {
+			t.Errorf("extracter %s has no fixtures in %s", name, filepath.Join(conformanceDir, conformanceManifest))
+		}
+	}
+}
+
+// lineDiff 按行比较expected和actual，只显示变化的行和前后diffContext行
+func lineDiff(expected, actual string) string {
+	a, b := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+
+	// lcs[i][j]是a[i:]和b[j:]的最长公共子序列的长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(a)+len(b))
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+
+	var out strings.Builder
+	skipped := false
+	for i, line := range lines {
+		near := false
+		for k := i - diffContext; k <= i+diffContext && !near; k++ {
+			near = k >= 0 && k < len(lines) && !strings.HasPrefix(lines[k], "  ")
+		}
+		if !near {
+			if !skipped {
+				out.WriteString("  ...\n")
+			}
+			skipped = true
+			continue
+		}
+		skipped = false
+		fmt.Fprintln(&out, line)
+	}
+	return out.String()
+}
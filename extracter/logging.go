@@ -0,0 +1,6 @@
+package common
+
+import "github.com/op/go-logging"
+
+// 和engine使用同一个模块名，这样engine配置的日志级别和输出格式对这儿也有效
+var log = logging.MustGetLogger("logging")
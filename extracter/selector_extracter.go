@@ -0,0 +1,460 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/twoflyliu/novel/cleaner"
+	"github.com/twoflyliu/novel/engine"
+)
+
+const (
+	SELECTOR_TEXT  = "text"  //合并所有的空白，默认的文本格式
+	SELECTOR_LINES = "lines" //<br>和块元素变成换行，每一行去掉首尾的空白，删除空行
+
+	// 每个SelectorExtracter保存的解析以后的页面数，所有的章节worker同时使用一个Extracter
+	PARSE_CACHE_SIZE = 2 * engine.THREAD_COUNT
+)
+
+// SelectorField 描述怎样从页面中提取一个字段
+//
+// Selector是CSS选择器，使用第一个匹配的元素。Attr不为空的时候取属性的值，否则按照Text取元素的文本，
+// 然后依次使用After和Pattern处理。Selector为空或者没有结果的时候在整个页面中使用Fallback正则表达式，
+// 和ExtracterPattern一样取第一个子匹配，Fallback的结果不再使用After和Pattern处理
+type SelectorField struct {
+	Selector string
+	Attr     string
+	Text     string //SELECTOR_TEXT或者SELECTOR_LINES
+	Remove   string //提取文本的时候忽略的子元素，比如"script, div"
+	After    string //只保留第一次出现的After后面的内容，比如"作者：测试"使用"："
+	Pattern  string //只保留第一个子匹配，没有子匹配的时候保留整个匹配
+	Fallback string
+}
+
+// SelectorExtracterPattern 是sites.json的SelectorExtracterMap中的一项
+type SelectorExtracterPattern struct {
+	NovelName              SelectorField
+	NovelAuthor            SelectorField
+	NovelIconUrl           SelectorField
+	NovelLastUpdateTime    SelectorField
+	NovelNewestChapterName SelectorField
+	NovelDescription       SelectorField
+	MenuItem               SelectorField //选择目录中所有章节的链接，Fallback需要url和标题两个子匹配
+	ChapterTitle           SelectorField
+	ChapterContent         SelectorField
+	SearchForm             SelectorField  //选择搜索表单，只使用Selector
+	SearchObjUrl           SelectorField  //选择搜索结果中的链接，提取的文本和小说名称相同的时候返回它的href，Fallback和SearchObjUrlPattern一样使用%s表示小说名称
+	ChapterNextPage        SelectorField  //可选，章节下一页的链接，Attr默认为href
+	MenuNextPage           SelectorField  //可选，目录下一页的链接，Attr默认为href
+	Clean                  []cleaner.Step //可选，继续清理ChapterContent提取出来的文本，比如删除广告行
+}
+
+var (
+	hiddenInputSelector = cascadia.MustCompile(`input[type="hidden"]`)
+	searchInputSelector = cascadia.MustCompile(`input[type="text"], input[type="search"], input:not([type])`)
+)
+
+// selectorField 是编译以后的SelectorField
+type selectorField struct {
+	selector cascadia.Selector
+	attr     string
+	lines    bool
+	remove   cascadia.Selector
+	after    string
+	pattern  *regexp.Regexp
+	fallback *regexp.Regexp
+}
+
+// SelectorExtracter 使用CSS选择器提取信息的Extracter，各个字段可以退回到正则表达式
+type SelectorExtracter struct {
+	name                   string
+	novelName              selectorField
+	novelAuthor            selectorField
+	novelIconUrl           selectorField
+	novelLastUpdateTime    selectorField
+	novelNewestChapterName selectorField
+	novelDescription       selectorField
+	menuItem               selectorField
+	chapterTitle           selectorField
+	chapterContent         selectorField
+	searchForm             selectorField
+	searchObjUrl           selectorField
+	searchObjUrlFallback   string
+	chapterNextPage        selectorField
+	menuNextPage           selectorField
+	clean                  *cleaner.Pipeline //为nil的时候不清理
+
+	mu    sync.Mutex            //保护解析以后的页面，同一个页面会连续提取多个字段
+	docs  map[string]*html.Node //以页面的内容为键
+	order []string              //docs中的页面，按照加入的顺序，超过PARSE_CACHE_SIZE的时候删除最早的
+}
+
+// NewSelectorExtracter 使用pattern创建Extracter
+// 选择器，正则表达式或者文本格式不合法的时候返回*engine.ParseError
+func NewSelectorExtracter(extracterName string, pattern *SelectorExtracterPattern) (*SelectorExtracter, error) {
+	e := &SelectorExtracter{name: extracterName, docs: make(map[string]*html.Node)}
+	var err error
+
+	// 只记录第一个错误
+	compile := func(fieldName string, field *SelectorField, regexField bool) (compiled selectorField) {
+		if err != nil {
+			return
+		}
+		compiled, err = compileSelectorField(extracterName+"."+fieldName, field, regexField)
+		return
+	}
+
+	e.novelName = compile("NovelName", &pattern.NovelName, true)
+	e.novelAuthor = compile("NovelAuthor", &pattern.NovelAuthor, true)
+	e.novelIconUrl = compile("NovelIconUrl", &pattern.NovelIconUrl, true)
+	e.novelLastUpdateTime = compile("NovelLastUpdateTime", &pattern.NovelLastUpdateTime, true)
+	e.novelNewestChapterName = compile("NovelNewestChapterName", &pattern.NovelNewestChapterName, true)
+	e.novelDescription = compile("NovelDescription", &pattern.NovelDescription, true)
+	e.menuItem = compile("MenuItem", &pattern.MenuItem, true)
+	e.chapterTitle = compile("ChapterTitle", &pattern.ChapterTitle, true)
+	e.chapterContent = compile("ChapterContent", &pattern.ChapterContent, true)
+	e.searchForm = compile("SearchForm", &pattern.SearchForm, false)
+	e.searchObjUrl = compile("SearchObjUrl", &pattern.SearchObjUrl, false)
+	e.chapterNextPage = compile("ChapterNextPage", &pattern.ChapterNextPage, true)
+	e.menuNextPage = compile("MenuNextPage", &pattern.MenuNextPage, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(pattern.Clean) > 0 {
+		if e.clean, err = cleaner.New(extracterName+".Clean", pattern.Clean); err != nil {
+			return nil, err
+		}
+	}
+	for _, next := range []*selectorField{&e.chapterNextPage, &e.menuNextPage} {
+		if len(next.attr) == 0 {
+			next.attr = "href" //链接的文本是"下一页"，没有用处
+		}
+	}
+
+	// Fallback中的%s在搜索的时候才替换为小说名称，这儿只检查替换以后是否合法
+	if fallback := pattern.SearchObjUrl.Fallback; len(fallback) > 0 {
+		if _, err := regexp.Compile(fmt.Sprintf(fallback, "name")); err != nil {
+			return nil, engine.NewParseError(extracterName+".SearchObjUrl.Fallback", fallback, err)
+		}
+		e.searchObjUrlFallback = fallback
+	}
+	return e, nil
+}
+
+// compileSelectorField 编译field，regexField为false的时候field不能使用Fallback
+func compileSelectorField(name string, field *SelectorField, regexField bool) (compiled selectorField, err error) {
+	if len(field.Selector) > 0 {
+		if compiled.selector, err = cascadia.Compile(field.Selector); err != nil {
+			return compiled, engine.NewParseError(name+".Selector", field.Selector, err)
+		}
+	}
+	if len(field.Remove) > 0 {
+		if compiled.remove, err = cascadia.Compile(field.Remove); err != nil {
+			return compiled, engine.NewParseError(name+".Remove", field.Remove, err)
+		}
+	}
+	switch field.Text {
+	case "", SELECTOR_TEXT:
+	case SELECTOR_LINES:
+		compiled.lines = true
+	default:
+		return compiled, engine.NewParseError(name+".Text", field.Text, errors.New("unknown text format"))
+	}
+	if len(field.Pattern) > 0 {
+		if compiled.pattern, err = compilePattern(name, "Pattern", field.Pattern); err != nil {
+			return
+		}
+	}
+	if len(field.Fallback) > 0 && regexField {
+		compiled.fallback, err = compilePattern(name, "Fallback", field.Fallback)
+	}
+	compiled.attr = field.Attr
+	compiled.after = field.After
+	return
+}
+
+// value 返回从n中提取出的字段的值
+func (f *selectorField) value(n *html.Node) string {
+	var value string
+	if len(f.attr) > 0 {
+		value = attrOf(n, f.attr)
+	} else {
+		var b strings.Builder
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeText(&b, c, f.remove, f.lines)
+		}
+		value = b.String()
+	}
+
+	if f.lines {
+		lines := make([]string, 0)
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				lines = append(lines, line)
+			}
+		}
+		value = strings.Join(lines, "\n")
+	} else {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+
+	if len(f.after) > 0 {
+		if index := strings.Index(value, f.after); index != -1 {
+			value = value[index+len(f.after):]
+		}
+	}
+	if f.pattern != nil {
+		matches := f.pattern.FindStringSubmatch(value)
+		switch {
+		case len(matches) > 1:
+			value = matches[1]
+		case len(matches) == 1:
+			value = matches[0]
+		default:
+			value = ""
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// writeText 将n中的文本写入到b中，忽略remove匹配的元素，script和style
+func writeText(b *strings.Builder, n *html.Node, remove cascadia.Selector, lines bool) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if remove != nil && remove.Match(n) {
+			return
+		}
+		switch n.DataAtom {
+		case atom.Script, atom.Style:
+			return
+		case atom.Br:
+			if lines {
+				b.WriteByte('\n')
+			}
+			return
+		}
+	default:
+		return
+	}
+
+	block := lines && cleaner.IsBlockElement(n.DataAtom)
+	if block {
+		b.WriteByte('\n')
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(b, c, remove, lines)
+	}
+	if block {
+		b.WriteByte('\n')
+	}
+}
+
+func attrOf(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// parse 返回fullPage的文档树，最近解析过的页面不再重新解析
+func (e *SelectorExtracter) parse(fullPage string) *html.Node {
+	e.mu.Lock()
+	doc, ok := e.docs[fullPage]
+	e.mu.Unlock()
+	if ok {
+		return doc
+	}
+
+	// 在锁的外面解析，所有的章节worker共用一个Extracter，可以同时解析不同的页面
+	doc, err := html.Parse(strings.NewReader(fullPage))
+	if err != nil {
+		log.Errorf("%s: parse page fail: %v", e.name, err)
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.docs[fullPage]; !ok {
+		if len(e.order) >= PARSE_CACHE_SIZE {
+			delete(e.docs, e.order[0])
+			e.order = e.order[1:]
+		}
+		e.docs[fullPage] = doc
+		e.order = append(e.order, fullPage)
+	}
+	return doc
+}
+
+// extract 使用field从fullPage中提取字段，选择器没有结果的时候使用Fallback
+func (e *SelectorExtracter) extract(field *selectorField, fullPage string) string {
+	if field.selector != nil {
+		if doc := e.parse(fullPage); doc != nil {
+			if n := field.selector.MatchFirst(doc); n != nil {
+				if value := field.value(n); len(value) > 0 {
+					return value
+				}
+			}
+		}
+	}
+	if field.fallback == nil {
+		return ""
+	}
+	log.Debugf("%s: selector found nothing, fall back to %q", e.name, field.fallback)
+	if matches := field.fallback.FindStringSubmatch(fullPage); len(matches) > 1 {
+		return strings.TrimSpace(matches[1])
+	}
+	return ""
+}
+
+// find 返回fullPage中第一个被selector匹配的元素
+func (e *SelectorExtracter) find(selector cascadia.Selector, fullPage string) *html.Node {
+	if selector == nil {
+		return nil
+	}
+	doc := e.parse(fullPage)
+	if doc == nil {
+		return nil
+	}
+	return selector.MatchFirst(doc)
+}
+
+// findAll 返回fullPage中所有被selector匹配的元素
+func (e *SelectorExtracter) findAll(selector cascadia.Selector, fullPage string) []*html.Node {
+	if selector == nil {
+		return nil
+	}
+	doc := e.parse(fullPage)
+	if doc == nil {
+		return nil
+	}
+	return selector.MatchAll(doc)
+}
+
+func (e *SelectorExtracter) ExtractNovelName(fullPage string) string {
+	return e.extract(&e.novelName, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractLastUpdateTime(fullPage string) string {
+	return e.extract(&e.novelLastUpdateTime, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractNovelAuthor(fullPage string) string {
+	return e.extract(&e.novelAuthor, fullPage)
+}
+
+// ExtractMenuList 返回以[[url1, menu1], [ur2, menu2], ...]形式返回，url为链接的href
+func (e *SelectorExtracter) ExtractMenuList(fullPage string) [][]string {
+	result := make([][]string, 0)
+	for _, n := range e.findAll(e.menuItem.selector, fullPage) {
+		if href, title := attrOf(n, "href"), e.menuItem.value(n); len(href) > 0 && len(title) > 0 {
+			result = append(result, []string{href, title})
+		}
+	}
+	if len(result) == 0 && e.menuItem.fallback != nil {
+		log.Debugf("%s: menu selector found nothing, fall back to %q", e.name, e.menuItem.fallback)
+		for _, v := range e.menuItem.fallback.FindAllStringSubmatch(fullPage, -1) {
+			if len(v) > 2 {
+				result = append(result, []string{v[1], v[2]})
+			}
+		}
+	}
+	return removeRepeatedMenuItems(result)
+}
+
+func (e *SelectorExtracter) ExtractNovelDescription(fullPage string) string {
+	return e.extract(&e.novelDescription, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractIconURL(menuPage string) string {
+	return e.extract(&e.novelIconUrl, menuPage)
+}
+
+func (e *SelectorExtracter) ExtractChapterTitle(fullPage string) string {
+	return e.extract(&e.chapterTitle, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractChapterContent(fullPage string) string {
+	return e.clean.Clean(e.extract(&e.chapterContent, fullPage))
+}
+
+func (e *SelectorExtracter) ExtractMenuURL(url string) string {
+	return menuURLOf(url)
+}
+
+func (e *SelectorExtracter) ExtractNewestLastChapterName(fullPage string) string {
+	return e.extract(&e.novelNewestChapterName, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractSearchFormHiddenValues(fullPage string) (values url.Values) {
+	values = make(url.Values)
+	form := e.find(e.searchForm.selector, fullPage)
+	if form == nil {
+		return
+	}
+	for _, n := range hiddenInputSelector.MatchAll(form) {
+		if name := attrOf(n, "name"); len(name) > 0 {
+			values.Set(name, attrOf(n, "value"))
+		}
+	}
+	return
+}
+
+func (e *SelectorExtracter) ExtractSearchFormMethodAndAction(fullPage string) (method string, actionUrl string) {
+	if form := e.find(e.searchForm.selector, fullPage); form != nil {
+		method, actionUrl = attrOf(form, "method"), attrOf(form, "action")
+	}
+	return
+}
+
+func (e *SelectorExtracter) ExtractSearchFormSearchFieldName(fullPage string) string {
+	form := e.find(e.searchForm.selector, fullPage)
+	if form == nil {
+		return ""
+	}
+	if n := searchInputSelector.MatchFirst(form); n != nil {
+		return attrOf(n, "name")
+	}
+	return ""
+}
+
+func (e *SelectorExtracter) ExtractObjURL(name string, searchPage string) (string, bool) {
+	for _, n := range e.findAll(e.searchObjUrl.selector, searchPage) {
+		if href := attrOf(n, "href"); len(href) > 0 && e.searchObjUrl.value(n) == name {
+			return href, true
+		}
+	}
+	if len(e.searchObjUrlFallback) == 0 {
+		return "", false
+	}
+
+	log.Debugf("%s: search result selector found nothing, fall back to %q", e.name, e.searchObjUrlFallback)
+	pattern, err := regexp.Compile(fmt.Sprintf(e.searchObjUrlFallback, regexp.QuoteMeta(name)))
+	if err != nil {
+		log.Errorf("Compile search object url pattern %q fail: %v", e.searchObjUrlFallback, err)
+		return "", false
+	}
+	if matches := pattern.FindStringSubmatch(searchPage); len(matches) > 1 {
+		return matches[1], true
+	}
+	return "", false
+}
+
+func (e *SelectorExtracter) ExtractChapterNextPage(fullPage string) string {
+	return e.extract(&e.chapterNextPage, fullPage)
+}
+
+func (e *SelectorExtracter) ExtractMenuNextPage(fullPage string) string {
+	return e.extract(&e.menuNextPage, fullPage)
+}
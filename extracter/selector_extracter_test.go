@@ -0,0 +1,158 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/twoflyliu/novel/engine"
+	"github.com/twoflyliu/novel/noveltest"
+)
+
+func TestFakeSiteXBQGSelector(t *testing.T) {
+	expected := newFakeNovel(5)
+	site := noveltest.NewServer(expected, noveltest.WithMarkup(noveltest.MarkupXBQG))
+	defer site.Close()
+
+	mgr := newFakeSiteEngine(t, site)
+
+	// www.xbiquge6.com仍然使用XBQGExtracter，在单独的主机上检查XBQGSelectorExtracter
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("create extracters fail: %v", err)
+	}
+	engine.RegisterExtracter(`^selector\.xbiquge6\.test$`, extracters["XBQGSelectorExtracter"])
+	host := "selector.xbiquge6.test"
+	menuURL := noveltest.URL(host, noveltest.MENU_PATH)
+	if _, ok := engine.MustSelectSuitableExtracter(menuURL).(*SelectorExtracter); !ok {
+		t.Fatalf("expected a SelectorExtracter for %s", menuURL)
+	}
+	if _, ok := engine.MustSelectSuitableExtracter(noveltest.URL("www.xbiquge6.com", noveltest.MENU_PATH)).(*ConfigExtracter); !ok {
+		t.Errorf("expected XBQGExtracter for www.xbiquge6.com")
+	}
+	novel, err := mgr.NovelByURL(context.Background(), menuURL)
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if novel.Name != expected.Name || novel.Author != expected.Author || novel.Description != expected.Description ||
+		novel.LastUpdateTime != "2019-07-01 08:00:00" || novel.NewestLastChapterName != "第5章 测试" {
+		t.Errorf("unexpected novel base info: %+v", novel)
+	}
+	checkChapters(t, novel, expected)
+
+	indexPage, err := engine.DownloadText(context.Background(), mgr.GetDownloader(), noveltest.URL(host, noveltest.INDEX_PATH), 0)
+	if err != nil {
+		t.Fatalf("download index fail: %v", err)
+	}
+	extracter := engine.MustSelectSuitableExtracter(menuURL)
+	if method, action := extracter.ExtractSearchFormMethodAndAction(indexPage); method != "get" || action != noveltest.SEARCH_PATH {
+		t.Errorf("unexpected search form %q %q", method, action)
+	}
+	if values := extracter.ExtractSearchFormHiddenValues(indexPage); values.Get("type") != "articlename" {
+		t.Errorf("unexpected hidden values %v", values)
+	}
+	if name := extracter.ExtractSearchFormSearchFieldName(indexPage); name != "s" {
+		t.Errorf("expected search field s, but got %q", name)
+	}
+}
+
+const selectorTestPage = `<html><head>
+<meta property="og:novel:book_name" content="备用书名"/>
+</head><body>
+<div class="info"><span class="author">作者:  甲&nbsp;乙 </span></div>
+<div id="content">第一段<br/>&nbsp;&nbsp;第二段<div class="ad">广告</div><p>第三段</p><script>ad();</script></div>
+<ul><li><a href="2.html" data-title="第二章">2</a></li><li><a href="1.html" data-title="第一章">1</a></li></ul>
+</body></html>`
+
+func TestSelectorExtracter(t *testing.T) {
+	e, err := NewSelectorExtracter("TestSelectorExtracter", &SelectorExtracterPattern{
+		NovelName:      SelectorField{Selector: "#info h1", Fallback: `content="(.*?)"`},
+		NovelAuthor:    SelectorField{Selector: ".info .author", After: ":"},
+		ChapterTitle:   SelectorField{Selector: "#content", Pattern: `第(.)段`},
+		ChapterContent: SelectorField{Selector: "#content", Text: SELECTOR_LINES, Remove: ".ad"},
+		MenuItem:       SelectorField{Selector: "ul a", Attr: "data-title"},
+	})
+	if err != nil {
+		t.Fatalf("NewSelectorExtracter fail: %v", err)
+	}
+
+	if name := e.ExtractNovelName(selectorTestPage); name != "备用书名" {
+		t.Errorf("expected the fallback name, but got %q", name)
+	}
+	if author := e.ExtractNovelAuthor(selectorTestPage); author != "甲 乙" {
+		t.Errorf("expected author 甲 乙, but got %q", author)
+	}
+	if title := e.ExtractChapterTitle(selectorTestPage); title != "一" {
+		t.Errorf("expected the first submatch, but got %q", title)
+	}
+	if content := e.ExtractChapterContent(selectorTestPage); content != "第一段\n第二段\n第三段" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if menu := e.ExtractMenuList(selectorTestPage); len(menu) != 2 || menu[0][0] != "2.html" || menu[1][1] != "第一章" {
+		t.Errorf("unexpected menu %v", menu)
+	}
+	if description := e.ExtractNovelDescription(selectorTestPage); description != "" {
+		t.Errorf("expected nothing for an empty field, but got %q", description)
+	}
+	if _, ok := e.ExtractObjURL("备用书名", selectorTestPage); ok {
+		t.Errorf("expected nothing without search result selector")
+	}
+
+	var parseErr *engine.ParseError
+	_, err = NewSelectorExtracter("BrokenExtracter", &SelectorExtracterPattern{MenuItem: SelectorField{Selector: "#list >"}})
+	if !errors.As(err, &parseErr) || parseErr.Field != "BrokenExtracter.MenuItem.Selector" {
+		t.Errorf("expected a parse error of the broken selector, but got %v", err)
+	}
+	_, err = NewSelectorExtracter("BrokenExtracter", &SelectorExtracterPattern{ChapterContent: SelectorField{Text: "markdown"}})
+	if !errors.As(err, &parseErr) || parseErr.Field != "BrokenExtracter.ChapterContent.Text" {
+		t.Errorf("expected a parse error of the unknown text format, but got %v", err)
+	}
+
+	// 名称和正则表达式的Extracter重复
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SelectorExtracterMap["BQGExtracter"] = SelectorExtracterPattern{}
+	if _, err := config.Sites(); !errors.As(err, &parseErr) || parseErr.Field != "SelectorExtracterMap" {
+		t.Errorf("expected a parse error of the duplicated extracter, but got %v", err)
+	}
+}
+
+// 章节worker共用一个Extracter，同时提取不同的页面，使用-race运行
+func TestSelectorExtracterConcurrent(t *testing.T) {
+	e, err := NewSelectorExtracter("TestSelectorExtracter", &SelectorExtracterPattern{
+		ChapterTitle:   SelectorField{Selector: "h1"},
+		ChapterContent: SelectorField{Selector: "#content", Text: SELECTOR_LINES},
+	})
+	if err != nil {
+		t.Fatalf("NewSelectorExtracter fail: %v", err)
+	}
+
+	pageCount := 3 * PARSE_CACHE_SIZE //超过缓存的大小，缓存的页面会被替换
+	var wg sync.WaitGroup
+	for i := 0; i < pageCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			page := fmt.Sprintf(`<h1>第%d章</h1><div id="content">第%d章的内容</div>`, i, i)
+			for j := 0; j < 5; j++ {
+				title, content := e.ExtractChapterTitle(page), e.ExtractChapterContent(page)
+				if title != fmt.Sprintf("第%d章", i) || content != fmt.Sprintf("第%d章的内容", i) {
+					t.Errorf("page %d: unexpected %q %q", i, title, content)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(e.docs) > PARSE_CACHE_SIZE || len(e.order) != len(e.docs) {
+		t.Errorf("expected at most %d parsed pages, but got %d", PARSE_CACHE_SIZE, len(e.docs))
+	}
+}
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twoflyliu/novel/engine"
+	"github.com/twoflyliu/novel/noveltest"
+)
+
+func newFakeNovel(count int) noveltest.Novel {
+	novel := noveltest.Novel{Name: "假小说", Author: "测试作者", Description: "一本用来测试的小说。"}
+	for i := 0; i < count; i++ {
+		novel.Chapters = append(novel.Chapters, noveltest.Chapter{
+			Title:   fmt.Sprintf("第%d章 测试", i+1),
+			Content: fmt.Sprintf("第%d章的第一段。\n第%d章的第二段。", i+1, i+1),
+		})
+	}
+	return novel
+}
+
+// registerDefaultSites 注册内置的sites.json
+func registerDefaultSites(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err == nil {
+		err = config.Register()
+	}
+	if err != nil {
+		t.Fatalf("register default sites fail: %v", err)
+	}
+}
+
+// newFakeSiteEngine 返回通过site访问网站的engine，site代理了所有的主机
+func newFakeSiteEngine(t *testing.T, site *noveltest.Server) *engine.Engine {
+	registerDefaultSites(t)
+	return engine.NewEngine(
+		engine.WithDownloaderOptions(engine.WithProxy(site.URL)),
+		engine.WithRetryPolicy(engine.RetryPolicy{InitialBackoff: time.Millisecond, Multiplier: 2, MaxElapsed: 5 * time.Second}),
+		engine.WithMaxRetries(3),
+		engine.WithCacheTTLs(nil),
+		engine.WithBaseDir(t.TempDir()),
+		engine.WithNovelDir(t.TempDir(), ""),
+		engine.WithIconDir(t.TempDir(), ".png"))
+}
+
+func checkChapters(t *testing.T, novel *engine.Novel, expected noveltest.Novel) {
+	if len(novel.Chapters) != len(expected.Chapters) {
+		t.Fatalf("expected %d chapters, but got %d", len(expected.Chapters), len(novel.Chapters))
+	}
+	for i, chapter := range novel.Chapters {
+		content := strings.Join(strings.Fields(chapter.Content), "\n")
+		if !chapter.IsComplete() || chapter.Title != expected.Chapters[i].Title || content != expected.Chapters[i].Content {
+			t.Errorf("chapter %d: expected %+v, but got %+v", i, expected.Chapters[i], chapter)
+		}
+	}
+}
+
+func TestFakeSiteBQG(t *testing.T) {
+	expected := newFakeNovel(6)
+	site := noveltest.NewServer(expected, noveltest.WithGBK())
+	defer site.Close()
+
+	// 目录页很慢，第2章两次500，第3章一次只发送一半
+	site.SetFault(noveltest.MENU_PATH, noveltest.Fault{Delay: 100 * time.Millisecond, Times: 1})
+	site.SetFault(noveltest.ChapterPath(1), noveltest.Fault{StatusCode: 500, Times: 2})
+	site.SetFault(noveltest.ChapterPath(2), noveltest.Fault{Truncate: true, Times: 1})
+
+	mgr := newFakeSiteEngine(t, site)
+	novel, err := mgr.NovelByURL(context.Background(), noveltest.URL("www.37zw.net", noveltest.MENU_PATH))
+	if err != nil {
+		t.Fatalf("NovelByURL fail: %v", err)
+	}
+	if novel.Name != expected.Name || novel.Author != expected.Author || novel.Description != expected.Description ||
+		novel.LastUpdateTime != "2019-07-01 08:00:00" || novel.NewestLastChapterName != "第6章 测试" {
+		t.Errorf("unexpected novel base info: %+v", novel)
+	}
+	checkChapters(t, novel, expected)
+	if n := site.Requests(noveltest.ChapterPath(1)); n != 3 {
+		t.Errorf("expected chapter 2 to be requested 3 times, but got %d", n)
+	}
+	if n := site.Requests(noveltest.ChapterPath(2)); n != 2 {
+		t.Errorf("expected chapter 3 to be requested 2 times, but got %d", n)
+	}
+
+	img, err := mgr.DownloadIcon(context.Background(), novel)
+	if err != nil || !bytes.Equal(img, noveltest.DefaultCover) {
+		t.Errorf("unexpected cover %d bytes, %v", len(img), err)
+	}
+
+	// 网站修改了第4章的标题，并且增加了两章
+	updated := newFakeNovel(8)
+	updated.Chapters[3].Title = "第4章 测试（修订）"
+	site.SetNovel(updated)
+	report, err := mgr.SyncNovel(context.Background(), novel)
+	if err != nil {
+		t.Fatalf("SyncNovel fail: %v", err)
+	}
+	if len(report.Added) != 2 || len(report.Changed) != 1 || len(report.Removed) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	checkChapters(t, novel, updated)
+}
+
+func TestFakeSiteXBQGSearch(t *testing.T) {
+	expected := newFakeNovel(2)
+	site := noveltest.NewServer(expected, noveltest.WithMarkup(noveltest.MarkupXBQG))
+	defer site.Close()
+
+	mgr := newFakeSiteEngine(t, site)
+	searchURL := noveltest.URL("www.xbiquge6.com", noveltest.SEARCH_PATH+"?keyword="+expected.Name)
+	searchPage, err := engine.DownloadText(context.Background(), mgr.GetDownloader(), searchURL, 0)
+	if err != nil {
+		t.Fatalf("search fail: %v", err)
+	}
+
+	extracter := engine.MustSelectSuitableExtracter(searchURL)
+	if objURL, ok := extracter.ExtractObjURL(expected.Name, searchPage); !ok || objURL != noveltest.MENU_PATH {
+		t.Errorf("expected %q, but got %q", noveltest.MENU_PATH, objURL)
+	}
+	if _, ok := extracter.ExtractObjURL("不存在", searchPage); ok {
+		t.Errorf("expected nothing for unknown novel")
+	}
+}
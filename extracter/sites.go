@@ -0,0 +1,207 @@
+package common
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+const (
+	SITES_CONFIG_FILE_NAME = "sites.json"
+	SITES_CONFIG_DIR_NAME  = "novel"    //在$XDG_CONFIG_HOME中的目录
+	SITES_CONFIG_EMBEDDED  = "embedded" //使用内置的sites.json时候FindSitesConfig返回的来源
+)
+
+// 编译时内置的sites.json，搜索路径中都没有的时候使用
+//
+//go:embed sites.json
+var defaultSitesConfig []byte
+
+// ParseSitesConfig 解析sites.json的内容，name只用来生成错误信息
+func ParseSitesConfig(name string, data []byte) (*SitesConfig, error) {
+	config := new(SitesConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, engine.NewParseError("SitesConfig", name, err)
+	}
+	return config, nil
+}
+
+// LoadSitesConfig 从path中加载sites.json
+func LoadSitesConfig(path string) (*SitesConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSitesConfig(path, data)
+}
+
+// LoadSitesConfigFS 从fsys中的name加载sites.json
+func LoadSitesConfigFS(fsys fs.FS, name string) (*SitesConfig, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSitesConfig(name, data)
+}
+
+// DefaultSitesConfig 返回编译时内置的sites.json
+func DefaultSitesConfig() (*SitesConfig, error) {
+	return ParseSitesConfig(SITES_CONFIG_EMBEDDED, defaultSitesConfig)
+}
+
+// SitesConfigSearchPath 返回查找sites.json的路径，依次为$XDG_CONFIG_HOME/novel，基目录，
+// 工作目录和可执行文件所在的目录(novel/app.py在novel目录中运行./backend)，重复的目录只返回一次
+func SitesConfigSearchPath(baseDir string) []string {
+	dirs := make([]string, 0, 4)
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, SITES_CONFIG_DIR_NAME))
+	}
+	if len(baseDir) == 0 {
+		baseDir = "."
+	}
+	dirs = append(dirs, baseDir, ".")
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+
+	paths := make([]string, 0, len(dirs))
+	seen := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		key := dir
+		if abs, err := filepath.Abs(dir); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			paths = append(paths, filepath.Join(dir, SITES_CONFIG_FILE_NAME))
+		}
+	}
+	return paths
+}
+
+// FindSitesConfig 加载sites.json，返回配置和它的来源
+//
+// path不为空的时候只加载path，否则使用SitesConfigSearchPath中第一个存在的文件，
+// 都不存在的时候使用内置的sites.json，这时source为SITES_CONFIG_EMBEDDED
+func FindSitesConfig(path string, baseDir string) (config *SitesConfig, source string, err error) {
+	source = locateSitesConfig(path, baseDir)
+	if source == SITES_CONFIG_EMBEDDED {
+		config, err = DefaultSitesConfig()
+	} else {
+		config, err = LoadSitesConfig(source)
+	}
+	return
+}
+
+// locateSitesConfig 返回FindSitesConfig使用的文件，使用内置的sites.json的时候返回SITES_CONFIG_EMBEDDED
+func locateSitesConfig(path string, baseDir string) string {
+	if len(path) > 0 {
+		return path
+	}
+	for _, candidate := range SitesConfigSearchPath(baseDir) {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return SITES_CONFIG_EMBEDDED
+}
+
+// RegisterSitesConfig 使用FindSitesConfig加载sites.json并且注册到engine中，返回配置的来源
+func RegisterSitesConfig(path string, baseDir string) (source string, err error) {
+	config, source, err := FindSitesConfig(path, baseDir)
+	if err != nil {
+		return source, err
+	}
+	if err := config.Register(); err != nil {
+		return source, fmt.Errorf("%s: %w", source, err)
+	}
+	return source, nil
+}
+
+// Extracters 创建ExtracterMap和SelectorExtracterMap中所有的Extracter，以名称为键
+func (config *SitesConfig) Extracters() (map[string]engine.Extracter, error) {
+	extracterMap := make(map[string]engine.Extracter)
+	for n, e := range config.ExtracterMap {
+		extracter, err := NewConfigExtracter(
+			n,
+			e.NovelNamePattern,
+			e.NovelAuhtorPattern,
+			e.NovelIconUrlPattern,
+			e.NovelLastUpdateTimePattern,
+			e.NovelNewestChapterNamePattern,
+			e.NovelDescriptionPattern,
+			e.MenuListPattern,
+			e.MenuItemPattern,
+			e.ChapterTitlePattern,
+			e.ChapterContentPattern,
+			e.CleanSteps(),
+			e.SearchFormPattern,
+			e.SearchFormMethodAttributePattern,
+			e.SearchFormHiddenFieldPattern,
+			e.SearchFormShowFieldPattern,
+			e.SearchObjUrlPattern,
+			e.ChapterNextPagePattern,
+			e.MenuNextPagePattern,
+		)
+		if err != nil {
+			return nil, err
+		}
+		extracterMap[n] = extracter
+	}
+	for n, e := range config.SelectorExtracterMap {
+		if _, ok := extracterMap[n]; ok {
+			return nil, engine.NewParseError("SelectorExtracterMap", n, errors.New("already defined in ExtracterMap"))
+		}
+		extracter, err := NewSelectorExtracter(n, &e)
+		if err != nil {
+			return nil, err
+		}
+		extracterMap[n] = extracter
+	}
+	return extracterMap, nil
+}
+
+// Sites 检查配置并且创建所有的Extracter，搜索源和网站设置
+func (config *SitesConfig) Sites() (*engine.Sites, error) {
+	extracterMap, err := config.Extracters()
+	if err != nil {
+		return nil, err
+	}
+
+	sites := &engine.Sites{Extracters: make(map[string]engine.Extracter)}
+	for _, e := range config.RegistryExtracterList {
+		extracter, ok := extracterMap[e.ExtracterRef]
+		if !ok {
+			return nil, engine.NewParseError("ExtracterRef", e.ExtracterRef, fmt.Errorf("extracter of %q is not defined", e.HostPattern))
+		}
+		sites.Extracters[e.HostPattern] = extracter
+	}
+	for _, s := range config.RegistrySearchList {
+		sites.SearchItems = append(sites.SearchItems, engine.NewSearcherItem(s.SearchUrlFmtStr, s.NeedEscape, s.GBKEncoding, s.Host))
+	}
+	for _, s := range config.SiteSettingList {
+		setting, err := s.siteSetting()
+		if err != nil {
+			return nil, fmt.Errorf("site setting of %q: %w", s.HostPattern, err)
+		}
+		sites.SiteSettings = append(sites.SiteSettings, engine.HostSiteSetting{HostPattern: s.HostPattern, Setting: setting})
+	}
+	return sites, nil
+}
+
+// Register 将配置中的Extracter，搜索源和网站设置注册到engine中，替换上一次注册的配置
+//
+// 先检查所有的配置，有错误的时候仍然使用上一次注册的配置
+func (config *SitesConfig) Register() error {
+	sites, err := config.Sites()
+	if err != nil {
+		return err
+	}
+	return engine.ReplaceSites(sites)
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/twoflyliu/novel/cleaner"
+	"github.com/twoflyliu/novel/engine"
+)
+
+func TestLoadSitesConfig(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil || len(config.ExtracterMap) != 2 || len(config.RegistryExtracterList) != 2 {
+		t.Fatalf("unexpected default sites config %+v, %v", config, err)
+	}
+
+	fsys := fstest.MapFS{
+		"sites.json":   {Data: defaultSitesConfig},
+		"corrupt.json": {Data: []byte(`{"ExtracterMap": `)},
+	}
+	if _, err := LoadSitesConfigFS(fsys, "sites.json"); err != nil {
+		t.Errorf("LoadSitesConfigFS fail: %v", err)
+	}
+	if _, err := LoadSitesConfigFS(fsys, "corrupt.json"); !errors.Is(err, engine.ErrParseFailed) {
+		t.Errorf("expected a parse error, but got %v", err)
+	}
+
+	// 不合法的正则表达式和不存在的ExtracterRef都返回错误，而不是结束进程
+	config.ExtracterMap["BrokenExtracter"] = ExtracterPattern{NovelNamePattern: "(", SearchObjUrlPattern: "%s"}
+	var parseErr *engine.ParseError
+	if err := config.Register(); !errors.As(err, &parseErr) || parseErr.Field != "BrokenExtracter.NovelNamePattern" {
+		t.Errorf("expected a parse error of the broken pattern, but got %v", err)
+	}
+	delete(config.ExtracterMap, "BrokenExtracter")
+	config.RegistryExtracterList = append(config.RegistryExtracterList, RegistryExtracter{"broken.test", "MissingExtracter"})
+	if err := config.Register(); !errors.As(err, &parseErr) || parseErr.Field != "ExtracterRef" {
+		t.Errorf("expected a parse error of the missing extracter, but got %v", err)
+	}
+	if extracter, _ := engine.SelectSuitableExtracter("http://broken.test/"); extracter != nil {
+		t.Errorf("nothing should be registered from a broken config")
+	}
+}
+
+func TestFindSitesConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	baseDir := t.TempDir()
+
+	// 搜索路径中没有的时候使用内置的sites.json
+	if _, source, err := FindSitesConfig("", baseDir); err != nil || source != SITES_CONFIG_EMBEDDED {
+		t.Errorf("expected embedded sites config, but got %q, %v", source, err)
+	}
+
+	path := filepath.Join(baseDir, SITES_CONFIG_FILE_NAME)
+	if err := os.WriteFile(path, []byte(`{"RegistrySearchList": [{"Host": "base.test"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, source, err := FindSitesConfig("", baseDir)
+	if err != nil || source != path || len(config.RegistrySearchList) != 1 {
+		t.Errorf("expected sites config in base dir, but got %q, %v", source, err)
+	}
+
+	// $XDG_CONFIG_HOME/novel优先于基目录
+	xdgPath := SitesConfigSearchPath(baseDir)[0]
+	os.MkdirAll(filepath.Dir(xdgPath), 0755)
+	if err := os.WriteFile(xdgPath, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, source, _ := FindSitesConfig("", baseDir); source != xdgPath {
+		t.Errorf("expected %q, but got %q", xdgPath, source)
+	}
+
+	// 明确指定的文件不存在的时候返回错误，不会使用其它的文件
+	if _, _, err := FindSitesConfig(filepath.Join(baseDir, "missing.json"), baseDir); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, but got %v", err)
+	}
+}
+
+// novel/app.py在novel目录中运行./backend，基目录是~/.novel/log，工作目录中的sites.json优先于内置的
+func TestFindSitesConfigInWorkingDir(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+	if err := os.WriteFile(SITES_CONFIG_FILE_NAME, []byte(`{"RegistrySearchList": [{"Host": "work.test"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, source, err := FindSitesConfig("", t.TempDir())
+	if err != nil || source != SITES_CONFIG_FILE_NAME || len(config.RegistrySearchList) != 1 ||
+		config.RegistrySearchList[0].Host != "work.test" {
+		t.Errorf("expected sites config in working dir, but got %q, %v", source, err)
+	}
+
+	// 基目录就是工作目录的时候只查找一次
+	if paths := SitesConfigSearchPath("."); len(paths) != 3 {
+		t.Errorf("expected xdg, working dir and executable dir, but got %v", paths)
+	}
+}
+
+const nextPageTestPage = `<div class="bottem">
+<a href="/0/761/1.html">上一章</a> <a href="/0/761/">目录</a> <a class="next" href="/0/761/2_2.html?p=2&amp;s=1">下一页</a>
+</div>`
+
+func TestNextPagePatterns(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := config.ExtracterMap["BQGExtracter"]
+	pattern.ChapterNextPagePattern = `\<a\s+class="next"\s+href="([^"]+)"\>下一页\</a\>`
+	config.ExtracterMap["BQGExtracter"] = pattern
+	selectorPattern := config.SelectorExtracterMap["XBQGSelectorExtracter"]
+	selectorPattern.ChapterNextPage = SelectorField{Selector: ".bottem a.next"}
+	config.SelectorExtracterMap["XBQGSelectorExtracter"] = selectorPattern
+
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("Extracters fail: %v", err)
+	}
+	expected := "/0/761/2_2.html?p=2&s=1"
+	for _, name := range []string{"BQGExtracter", "XBQGSelectorExtracter"} {
+		pager, ok := extracters[name].(engine.ChapterPager)
+		if !ok {
+			t.Fatalf("%s is not a ChapterPager", name)
+		}
+		if next := pager.ExtractChapterNextPage(nextPageTestPage); next != expected {
+			t.Errorf("%s: expected %q, but got %q", name, expected, next)
+		}
+		// 没有设置的时候目录只有一页
+		if next := extracters[name].(engine.MenuPager).ExtractMenuNextPage(nextPageTestPage); next != "" {
+			t.Errorf("%s: expected no menu next page, but got %q", name, next)
+		}
+	}
+
+	pattern.MenuNextPagePattern = "("
+	config.ExtracterMap["BQGExtracter"] = pattern
+	var parseErr *engine.ParseError
+	if _, err := config.Extracters(); !errors.As(err, &parseErr) || parseErr.Field != "BQGExtracter.MenuNextPagePattern" {
+		t.Errorf("expected a parse error of MenuNextPagePattern, but got %v", err)
+	}
+}
+
+func TestCleanSteps(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chapter := readConformancePage(t, "bqg", "chapter.html")
+
+	// 旧的sites.json中的BrElementPattern等设置转换成同样效果的步骤
+	pattern := config.ExtracterMap["BQGExtracter"]
+	pattern.Clean = nil
+	pattern.BrElementPattern = `\<br\s*/\>`
+	pattern.EscapeElementPattern = `&[\s\S]+?;`
+	pattern.ScriptElementPattern = `\<script[\s\S]+?\</script\>`
+	config.ExtracterMap["BQGExtracter"] = pattern
+	extracters, err := config.Extracters()
+	if err != nil {
+		t.Fatalf("Extracters fail: %v", err)
+	}
+	expected := "河水很急，渡船只有一条。\n\n\n\n船夫看了他一眼，说：过河要三文钱。\n\n\n\n他摸了摸口袋，只有两文。\n\n\n\n最新章节请访问www.37zw.net"
+	if content := extracters["BQGExtracter"].ExtractChapterContent(chapter); content != expected {
+		t.Errorf("expected %q, but got %q", expected, content)
+	}
+
+	// 都没有设置的时候使用默认的步骤
+	pattern.BrElementPattern, pattern.EscapeElementPattern, pattern.ScriptElementPattern = "", "", ""
+	if steps := pattern.CleanSteps(); cleaner.FormatSteps(steps) != cleaner.Default().String() {
+		t.Errorf("expected default steps, but got %v", steps)
+	}
+
+	pattern.Clean = []cleaner.Step{{Type: cleaner.StepRemove}}
+	config.ExtracterMap["BQGExtracter"] = pattern
+	var parseErr *engine.ParseError
+	if _, err := config.Extracters(); !errors.As(err, &parseErr) || parseErr.Field != "BQGExtracter.Clean[0].Pattern" {
+		t.Errorf("expected a parse error of the clean step, but got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/twoflyliu/novel/cleaner"
+	"github.com/twoflyliu/novel/engine"
+)
+
+// PageKind 是检查的页面的类型，决定哪些字段应该能够提取出来
+type PageKind string
+
+const (
+	PageMenu    PageKind = "menu"    //目录页，包含小说的基本信息
+	PageChapter PageKind = "chapter" //章节页
+	PageIndex   PageKind = "index"   //包含搜索表单的页面，一般是首页
+	PageSearch  PageKind = "search"  //搜索结果页
+)
+
+// FieldStatus 是一个字段的检查结果
+type FieldStatus string
+
+const (
+	FieldOK       FieldStatus = "ok"
+	FieldEmpty    FieldStatus = "empty"    //这种页面上应该有这个字段，但是没有提取出来
+	FieldMismatch FieldStatus = "mismatch" //提取出来了，但是结果中还有html等明显的问题
+	FieldSkipped  FieldStatus = "-"        //这种页面上没有这个字段
+)
+
+// FieldReport 是使用Extracter提取一个字段的结果
+type FieldReport struct {
+	Field   string //字段名称，和Extracter的方法对应，比如NovelName
+	Value   string //MenuList和SearchFormHiddenValues每一项占一行
+	Pattern string //提取这个字段使用的正则表达式或者选择器
+	Status  FieldStatus
+	Problem string
+}
+
+// PageTest 描述要检查的页面
+type PageTest struct {
+	Site      string //ExtracterMap或者SelectorExtracterMap中的名称，也可以是主机名称或者页面的url
+	Kind      PageKind
+	URL       string //页面的url，用来检查ExtractMenuURL，可以为空
+	NovelName string //在搜索结果页中查找的小说，Kind为PageSearch的时候不能为空
+}
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`<[a-zA-Z/!][^>]*>`)
+	htmlEntityPattern = regexp.MustCompile(`&([a-zA-Z]+|#[0-9]+);`)
+)
+
+// pageFields 是每种页面上应该能够提取出来的字段
+var pageFields = map[PageKind][]string{
+	PageMenu:    {"NovelName", "NovelAuthor", "LastUpdateTime", "NewestLastChapterName", "NovelDescription", "IconURL", "MenuList"},
+	PageChapter: {"ChapterTitle", "ChapterContent"},
+	PageIndex:   {"SearchFormMethod", "SearchFormAction", "SearchFormSearchFieldName"},
+	PageSearch:  {"ObjURL"},
+}
+
+// textFields 是应该只包含文本的字段，其它的字段是url或者表单中的名称
+var textFields = map[string]bool{
+	"NovelName": true, "NovelAuthor": true, "LastUpdateTime": true, "NewestLastChapterName": true,
+	"NovelDescription": true, "MenuList": true, "ChapterTitle": true, "ChapterContent": true,
+}
+
+// ResolveExtracter 返回site对应的Extracter的名称
+// site可以是Extracter的名称，也可以是RegistryExtracterList中HostPattern匹配的主机名称或者url
+func (config *SitesConfig) ResolveExtracter(site string) (string, bool) {
+	if _, ok := config.ExtracterMap[site]; ok {
+		return site, true
+	}
+	if _, ok := config.SelectorExtracterMap[site]; ok {
+		return site, true
+	}
+	for _, e := range config.RegistryExtracterList {
+		if m, _ := regexp.MatchString(e.HostPattern, site); m {
+			return e.ExtracterRef, true
+		}
+	}
+	return "", false
+}
+
+// fieldPatterns 返回名称为name的Extracter提取每个字段使用的正则表达式或者选择器
+func (config *SitesConfig) fieldPatterns(name string) map[string]string {
+	if p, ok := config.ExtracterMap[name]; ok {
+		return map[string]string{
+			"NovelName":                 p.NovelNamePattern,
+			"NovelAuthor":               p.NovelAuhtorPattern,
+			"LastUpdateTime":            p.NovelLastUpdateTimePattern,
+			"NewestLastChapterName":     p.NovelNewestChapterNamePattern,
+			"NovelDescription":          p.NovelDescriptionPattern,
+			"IconURL":                   p.NovelIconUrlPattern,
+			"MenuList":                  p.MenuListPattern + " => " + p.MenuItemPattern,
+			"ChapterTitle":              p.ChapterTitlePattern,
+			"ChapterContent":            p.ChapterContentPattern + " => " + cleaner.FormatSteps(p.CleanSteps()),
+			"SearchFormMethod":          p.SearchFormMethodAttributePattern,
+			"SearchFormAction":          p.SearchFormMethodAttributePattern,
+			"SearchFormHiddenValues":    p.SearchFormPattern + " => " + p.SearchFormHiddenFieldPattern,
+			"SearchFormSearchFieldName": p.SearchFormPattern + " => " + p.SearchFormShowFieldPattern,
+			"ObjURL":                    p.SearchObjUrlPattern,
+			"ChapterNextPage":           p.ChapterNextPagePattern,
+			"MenuNextPage":              p.MenuNextPagePattern,
+		}
+	}
+	p := config.SelectorExtracterMap[name]
+	chapterContent := p.ChapterContent.String()
+	if len(p.Clean) > 0 {
+		chapterContent += " => " + cleaner.FormatSteps(p.Clean)
+	}
+	return map[string]string{
+		"NovelName":                 p.NovelName.String(),
+		"NovelAuthor":               p.NovelAuthor.String(),
+		"LastUpdateTime":            p.NovelLastUpdateTime.String(),
+		"NewestLastChapterName":     p.NovelNewestChapterName.String(),
+		"NovelDescription":          p.NovelDescription.String(),
+		"IconURL":                   p.NovelIconUrl.String(),
+		"MenuList":                  p.MenuItem.String(),
+		"ChapterTitle":              p.ChapterTitle.String(),
+		"ChapterContent":            chapterContent,
+		"SearchFormMethod":          p.SearchForm.String(),
+		"SearchFormAction":          p.SearchForm.String(),
+		"SearchFormHiddenValues":    p.SearchForm.String(),
+		"SearchFormSearchFieldName": p.SearchForm.String(),
+		"ObjURL":                    p.SearchObjUrl.String(),
+		"ChapterNextPage":           p.ChapterNextPage.String(),
+		"MenuNextPage":              p.MenuNextPage.String(),
+	}
+}
+
+// TestPage 使用test.Site对应的Extracter的所有方法提取page，返回每个字段的结果
+// 只有test.Kind这种页面上应该有的字段为空的时候才认为有问题
+func (config *SitesConfig) TestPage(test *PageTest, page string) ([]FieldReport, error) {
+	name, ok := config.ResolveExtracter(test.Site)
+	if !ok {
+		return nil, fmt.Errorf("no extracter for site %q", test.Site)
+	}
+	if _, ok := pageFields[test.Kind]; !ok {
+		return nil, engine.NewParseError("PageKind", string(test.Kind), errors.New("expect menu, chapter, index or search"))
+	}
+	if test.Kind == PageSearch && len(test.NovelName) == 0 {
+		return nil, errors.New("the novel name to look for in search results is empty")
+	}
+	extracters, err := config.Extracters()
+	if err != nil {
+		return nil, err
+	}
+	e := extracters[name]
+
+	values := make(map[string]string)
+	values["NovelName"] = e.ExtractNovelName(page)
+	values["NovelAuthor"] = e.ExtractNovelAuthor(page)
+	values["LastUpdateTime"] = e.ExtractLastUpdateTime(page)
+	values["NewestLastChapterName"] = e.ExtractNewestLastChapterName(page)
+	values["NovelDescription"] = e.ExtractNovelDescription(page)
+	values["IconURL"] = e.ExtractIconURL(page)
+	menuList := e.ExtractMenuList(page)
+	menuLines := make([]string, 0, len(menuList))
+	for _, item := range menuList {
+		menuLines = append(menuLines, strings.Join(item, " "))
+	}
+	values["MenuList"] = strings.Join(menuLines, "\n")
+	values["ChapterTitle"] = e.ExtractChapterTitle(page)
+	values["ChapterContent"] = e.ExtractChapterContent(page)
+	if len(test.URL) > 0 {
+		values["MenuURL"] = e.ExtractMenuURL(test.URL)
+	}
+	values["SearchFormMethod"], values["SearchFormAction"] = e.ExtractSearchFormMethodAndAction(page)
+	hiddenValues := e.ExtractSearchFormHiddenValues(page)
+	hiddenLines := make([]string, 0, len(hiddenValues))
+	for k := range hiddenValues {
+		hiddenLines = append(hiddenLines, k+"="+hiddenValues.Get(k))
+	}
+	sort.Strings(hiddenLines)
+	values["SearchFormHiddenValues"] = strings.Join(hiddenLines, "\n")
+	values["SearchFormSearchFieldName"] = e.ExtractSearchFormSearchFieldName(page)
+	if len(test.NovelName) > 0 {
+		values["ObjURL"], _ = e.ExtractObjURL(test.NovelName, page)
+	}
+	if pager, ok := e.(engine.ChapterPager); ok {
+		values["ChapterNextPage"] = pager.ExtractChapterNextPage(page)
+	}
+	if pager, ok := e.(engine.MenuPager); ok {
+		values["MenuNextPage"] = pager.ExtractMenuNextPage(page)
+	}
+
+	expected := make(map[string]bool)
+	for _, field := range pageFields[test.Kind] {
+		expected[field] = true
+	}
+	expected["MenuURL"] = test.Kind == PageChapter && len(test.URL) > 0
+	patterns := config.fieldPatterns(name)
+	fields := []string{"NovelName", "NovelAuthor", "LastUpdateTime", "NewestLastChapterName", "NovelDescription", "IconURL",
+		"MenuList", "ChapterTitle", "ChapterContent", "MenuURL", "SearchFormMethod", "SearchFormAction",
+		"SearchFormHiddenValues", "SearchFormSearchFieldName", "ObjURL", "ChapterNextPage", "MenuNextPage"}
+	reports := make([]FieldReport, 0, len(fields))
+	for _, field := range fields {
+		report := FieldReport{Field: field, Value: values[field], Pattern: patterns[field], Status: FieldOK}
+		switch {
+		case len(report.Value) == 0 && field == "ObjURL" && expected[field]:
+			report.Status, report.Problem = FieldEmpty, fmt.Sprintf("no search result matches %q", test.NovelName)
+		case len(report.Value) == 0 && expected[field]:
+			report.Status, report.Problem = FieldEmpty, "the pattern matched nothing"
+		case len(report.Value) == 0:
+			report.Status = FieldSkipped
+		default:
+			report.Problem = checkFieldValue(field, report.Value, menuList)
+		}
+		if len(report.Problem) > 0 && report.Status == FieldOK {
+			report.Status = FieldMismatch
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// checkFieldValue 返回提取出来的值中明显的问题，没有问题的时候返回空字符串
+func checkFieldValue(field string, value string, menuList [][]string) string {
+	if field == "MenuList" {
+		for i, item := range menuList {
+			if len(item) < 2 || len(strings.TrimSpace(item[0])) == 0 || len(strings.TrimSpace(item[1])) == 0 {
+				return fmt.Sprintf("menu item %d has an empty url or title", i)
+			}
+		}
+	}
+	if !textFields[field] {
+		return ""
+	}
+	if tag := htmlTagPattern.FindString(value); len(tag) > 0 {
+		return fmt.Sprintf("still contains html tag %q", tag)
+	}
+	if entity := htmlEntityPattern.FindString(value); len(entity) > 0 {
+		return fmt.Sprintf("still contains html entity %q", entity)
+	}
+	return ""
+}
+
+// String 返回所有不为空的设置，用来在报告中显示
+func (f SelectorField) String() string {
+	parts := make([]string, 0, 7)
+	for _, kv := range [][2]string{
+		{"Selector", f.Selector}, {"Attr", f.Attr}, {"Text", f.Text}, {"Remove", f.Remove},
+		{"After", f.After}, {"Pattern", f.Pattern}, {"Fallback", f.Fallback},
+	} {
+		if len(kv[1]) > 0 {
+			parts = append(parts, fmt.Sprintf("%s=`%s`", kv[0], kv[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
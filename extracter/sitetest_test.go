@@ -0,0 +1,107 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twoflyliu/novel/cleaner"
+)
+
+func readConformancePage(t *testing.T, dir string, name string) string {
+	page, err := os.ReadFile(filepath.Join(conformanceDir, dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(page)
+}
+
+func findReport(t *testing.T, reports []FieldReport, field string) FieldReport {
+	for _, report := range reports {
+		if report.Field == field {
+			return report
+		}
+	}
+	t.Fatalf("no report of %s", field)
+	return FieldReport{}
+}
+
+func TestSitesConfigTestPage(t *testing.T) {
+	config, err := DefaultSitesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 使用主机名称选择Extracter，目录页上所有的字段都能提取出来
+	menu := readConformancePage(t, "bqg", "menu.html")
+	reports, err := config.TestPage(&PageTest{Site: "www.37zw.net", Kind: PageMenu}, menu)
+	if err != nil {
+		t.Fatalf("TestPage fail: %v", err)
+	}
+	for _, report := range reports {
+		if report.Status != FieldOK && report.Status != FieldSkipped {
+			t.Errorf("unexpected report %+v", report)
+		}
+	}
+	if report := findReport(t, reports, "MenuList"); strings.Count(report.Value, "\n") != 4 {
+		t.Errorf("expected 5 menu items, but got %q", report.Value)
+	}
+	if report := findReport(t, reports, "ChapterTitle"); report.Status != FieldSkipped {
+		t.Errorf("chapter title is not expected on a menu page: %+v", report)
+	}
+
+	// 正则表达式在嵌套的div处结束，清理的时候没有删除标签，章节内容中还有html
+	chapter := readConformancePage(t, "xbqg", "chapter.html")
+	xbqg := config.ExtracterMap["XBQGExtracter"]
+	xbqg.Clean = []cleaner.Step{{Type: cleaner.StepReplace, Pattern: `<br\s*/>`, Replace: "\n"}, {Type: cleaner.StepDecodeEntities}}
+	config.ExtracterMap["XBQGExtracter"] = xbqg
+	test := &PageTest{Site: "XBQGExtracter", Kind: PageChapter, URL: "https://www.xbiquge6.com/81_81519/2.html"}
+	reports, err = config.TestPage(test, chapter)
+	if err != nil {
+		t.Fatalf("TestPage fail: %v", err)
+	}
+	if report := findReport(t, reports, "ChapterContent"); report.Status != FieldMismatch || !strings.Contains(report.Problem, "<div") ||
+		report.Pattern != xbqg.ChapterContentPattern+" => "+cleaner.FormatSteps(xbqg.Clean) {
+		t.Errorf("expected a mismatch of html tag, but got %+v", report)
+	}
+	if report := findReport(t, reports, "MenuURL"); report.Status != FieldOK || report.Value != "https://www.xbiquge6.com/81_81519/" {
+		t.Errorf("unexpected menu url %+v", report)
+	}
+	test.Site = "XBQGSelectorExtracter"
+	reports, _ = config.TestPage(test, chapter)
+	if report := findReport(t, reports, "ChapterContent"); report.Status != FieldOK {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// 简介中仍然有<p>，目录为空
+	pattern := config.ExtracterMap["BQGExtracter"]
+	pattern.NovelDescriptionPattern = `\<div\s+id="intro"\>\s*\<p\>([\s\S]+?)\</p\>\s*\</div\>`
+	pattern.MenuListPattern = `\<div\s+id="chapters"[\s\S]+?\</div\>`
+	config.ExtracterMap["BQGExtracter"] = pattern
+	reports, _ = config.TestPage(&PageTest{Site: "BQGExtracter", Kind: PageMenu}, menu)
+	if report := findReport(t, reports, "NovelDescription"); report.Status != FieldMismatch || !strings.Contains(report.Value, "<p>") {
+		t.Errorf("expected a mismatch of <p>, but got %+v", report)
+	}
+	if report := findReport(t, reports, "MenuList"); report.Status != FieldEmpty || report.Pattern == "" {
+		t.Errorf("expected an empty menu list, but got %+v", report)
+	}
+
+	// 搜索结果页
+	search := readConformancePage(t, "bqg", "search.html")
+	reports, _ = config.TestPage(&PageTest{Site: "BQGExtracter", Kind: PageSearch, NovelName: "青山记"}, search)
+	if report := findReport(t, reports, "ObjURL"); report.Status != FieldOK || report.Value != "/0/761/" {
+		t.Errorf("unexpected search result %+v", report)
+	}
+	reports, _ = config.TestPage(&PageTest{Site: "BQGExtracter", Kind: PageSearch, NovelName: "不存在"}, search)
+	if report := findReport(t, reports, "ObjURL"); report.Status != FieldEmpty {
+		t.Errorf("expected no search result, but got %+v", report)
+	}
+
+	if _, err := config.TestPage(&PageTest{Site: "missing.test", Kind: PageMenu}, menu); err == nil {
+		t.Errorf("expected an error for unknown site")
+	}
+	if _, err := config.TestPage(&PageTest{Site: "BQGExtracter", Kind: "cover"}, menu); err == nil {
+		t.Errorf("expected an error for unknown page kind")
+	}
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"context"
+	"crypto/sha1"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_WATCH_INTERVAL = 2 * time.Second //检查sites.json是否变化的间隔
+)
+
+// SitesConfigWatcher 在sites.json变化的时候重新加载并且注册到engine中
+//
+// 新的配置有错误的时候继续使用原来的配置，直到文件再次变化或者调用Reload
+type SitesConfigWatcher struct {
+	path    string
+	baseDir string
+
+	mu       sync.Mutex
+	source   string   //上一次加载的文件
+	checksum [20]byte //上一次加载的内容的摘要
+	handler  func(source string, err error)
+}
+
+// NewSitesConfigWatcher 创建监视器，path和baseDir的含义和FindSitesConfig相同
+func NewSitesConfigWatcher(path string, baseDir string) *SitesConfigWatcher {
+	return &SitesConfigWatcher{path: path, baseDir: baseDir}
+}
+
+// SetReloadHandler 设置每次重新加载以后调用的函数，err不为nil表示新的配置被拒绝了
+func (w *SitesConfigWatcher) SetReloadHandler(handler func(source string, err error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handler = handler
+}
+
+// Reload 立即重新加载sites.json，返回配置的来源
+func (w *SitesConfigWatcher) Reload() (source string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	source, data, err := w.readLocked()
+	if err == nil {
+		err = w.registerLocked(source, data)
+	}
+	w.notifyLocked(source, err)
+	return source, err
+}
+
+// Watch 每隔interval检查一次sites.json，内容变化的时候重新加载，直到ctx被取消
+// interval <= 0的时候使用DEFAULT_WATCH_INTERVAL
+func (w *SitesConfigWatcher) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_WATCH_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reloadIfChanged()
+		}
+	}
+}
+
+// ReloadOnSignal 收到sigs中的信号(一般是syscall.SIGHUP)的时候重新加载，直到ctx被取消
+func (w *SitesConfigWatcher) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			w.Reload()
+		}
+	}
+}
+
+func (w *SitesConfigWatcher) reloadIfChanged() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	source, data, err := w.readLocked()
+	if err != nil {
+		log.Debugf("Read sites config from %s fail: %v", source, err) //编辑器保存的时候文件可能暂时不存在
+		return
+	}
+	if source == w.source && sha1.Sum(data) == w.checksum {
+		return
+	}
+	w.notifyLocked(source, w.registerLocked(source, data))
+}
+
+// readLocked 读取当前应该使用的sites.json
+func (w *SitesConfigWatcher) readLocked() (source string, data []byte, err error) {
+	source = locateSitesConfig(w.path, w.baseDir)
+	if source == SITES_CONFIG_EMBEDDED {
+		return source, defaultSitesConfig, nil
+	}
+	data, err = os.ReadFile(source)
+	return
+}
+
+// registerLocked 解析并且注册data，无论成功与否都记录下来，文件没有再次变化的时候不会重复加载
+func (w *SitesConfigWatcher) registerLocked(source string, data []byte) error {
+	w.source, w.checksum = source, sha1.Sum(data)
+	config, err := ParseSitesConfig(source, data)
+	if err != nil {
+		return err
+	}
+	return config.Register()
+}
+
+func (w *SitesConfigWatcher) notifyLocked(source string, err error) {
+	if err != nil {
+		log.Errorf("Reload sites config from %s fail, keep the old one: %v", source, err)
+	} else {
+		log.Debugf("Reload sites config from %s", source)
+	}
+	if w.handler != nil {
+		w.handler(source, err)
+	}
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twoflyliu/novel/engine"
+)
+
+func TestSitesConfigWatcher(t *testing.T) {
+	defer registerDefaultSites(t)
+
+	path := filepath.Join(t.TempDir(), SITES_CONFIG_FILE_NAME)
+	sitesJSON := strings.Replace(string(defaultSitesConfig), "www.xbiquge6.com", "www.watch.test", -1)
+	if err := os.WriteFile(path, []byte(sitesJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan error, 10)
+	watcher := NewSitesConfigWatcher(path, "")
+	watcher.SetReloadHandler(func(source string, err error) { reloads <- err })
+	if _, err := watcher.Reload(); err != nil {
+		t.Fatalf("Reload fail: %v", err)
+	}
+	<-reloads
+	if engine.AutoSelectExtracter("http://www.watch.test/") == nil {
+		t.Fatalf("extracter of www.watch.test is not registered")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx, 10*time.Millisecond)
+
+	// 错误的配置被拒绝
+	broken := strings.Replace(sitesJSON, `"ChapterTitlePattern": "`, `"ChapterTitlePattern": "(`, 1)
+	if err := os.WriteFile(path, []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitReload(t, reloads); err == nil {
+		t.Errorf("expected broken config to be rejected")
+	}
+	if engine.AutoSelectExtracter("http://www.watch.test/") == nil {
+		t.Errorf("broken config should not replace the working one")
+	}
+
+	// 修改以后的配置替换原来的配置
+	renamed := strings.Replace(sitesJSON, "www.watch.test", "www.renamed.test", -1)
+	if err := os.WriteFile(path, []byte(renamed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitReload(t, reloads); err != nil {
+		t.Errorf("reload fail: %v", err)
+	}
+	if engine.AutoSelectExtracter("http://www.watch.test/") != nil || engine.AutoSelectExtracter("http://www.renamed.test/") == nil {
+		t.Errorf("sites config is not reloaded")
+	}
+}
+
+func waitReload(t *testing.T, reloads chan error) error {
+	select {
+	case err := <-reloads:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sites config is not reloaded")
+		return nil
+	}
+}
@@ -21,6 +21,12 @@ install() {
             go install
             cd ..
             ;;
+        "cleaner")
+            echo install cleaner...
+            cd cleaner
+            go install
+            cd ..
+            ;;
         "extracter")
             echo install extracter...
             cd extracter
@@ -47,12 +53,35 @@ install() {
             fi
             cd ..
             ;;
+        "cachectl")
+            echo build cachectl...
+            cd cachectl
+            go build
+            result=$?
+            if [[ $result -eq '0' ]]; then
+                mv ./cachectl ../novel
+            fi
+            cd ..
+            ;;
+        "sitectl")
+            echo build sitectl...
+            cd sitectl
+            go build
+            result=$?
+            if [[ $result -eq '0' ]]; then
+                mv ./sitectl ../novel
+            fi
+            cd ..
+            ;;
         "all")
             install tool
             install engine
+            install cleaner
             install extracter
             install search
             install backend
+            install cachectl
+            install sitectl
             ;;
         *)
             echo unsupport install command!:$1
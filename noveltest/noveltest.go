@@ -0,0 +1,263 @@
+// noveltest package provides a fake novel site for integration testing, like net/http/httptest.
+//
+// Server renders a configurable novel with the same markup as the BQGExtracter and XBQGExtracter
+// patterns in sites.json, so extracters and the engine can be tested without the real sites.
+// Faults such as slow responses, 500s and truncated bodies can be injected per path.
+//
+// The server also works as an http proxy: requests for any host are served from the fake novel,
+// so the extracter registered for a real host such as www.37zw.net is selected by the engine.
+package noveltest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twoflyliu/novel/tool"
+)
+
+const (
+	MENU_PATH   = "/book/1/"      //目录页的路径
+	COVER_PATH  = "/cover/1s.jpg" //封面的路径
+	SEARCH_PATH = "/search.php"   //搜索结果页的路径，其它带有查询参数的路径也返回搜索结果
+	INDEX_PATH  = "/"             //首页，包含搜索表单
+	HTML_SUFFIX = ".html"         //章节页的后缀
+	DATE_LAYOUT = "2006-01-02 15:04:05"
+)
+
+// Markup 是页面的格式，只有搜索结果页不同
+type Markup string
+
+const (
+	MarkupBQG  Markup = "BQGExtracter"  //笔趣阁和37中文网
+	MarkupXBQG Markup = "XBQGExtracter" //新笔趣阁
+)
+
+// Chapter 是假小说的一个章节，Content中的每一行是一个段落
+type Chapter struct {
+	Title   string
+	Content string
+}
+
+// Novel 是网站上的假小说
+type Novel struct {
+	Name           string
+	Author         string
+	Description    string
+	LastUpdateTime time.Time
+	Chapters       []Chapter
+	Cover          []byte //为nil的时候使用DefaultCover
+}
+
+// Fault 是注入到某个路径的故障
+type Fault struct {
+	Delay      time.Duration //响应之前等待的时间
+	StatusCode int           //不为0的时候返回这个状态码，而不是页面
+	Truncate   bool          //只发送一半的内容，然后断开连接
+	Times      int           //故障发生的次数，0表示一直发生
+}
+
+// Server 是一个假的小说网站
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	novel    Novel
+	markup   Markup
+	gbk      bool
+	faults   map[string]*Fault
+	requests map[string]int
+}
+
+// Option 用来配置Server
+type Option func(*Server)
+
+// WithMarkup 设置页面的格式，默认为MarkupBQG
+func WithMarkup(markup Markup) Option {
+	return func(s *Server) {
+		s.markup = markup
+	}
+}
+
+// WithGBK 使用gbk编码页面，和大部分中文小说网站一样只在meta中声明编码
+func WithGBK() Option {
+	return func(s *Server) {
+		s.gbk = true
+	}
+}
+
+// NewServer 启动一个提供novel的假网站，使用完毕以后需要调用Close
+func NewServer(novel Novel, opts ...Option) *Server {
+	s := &Server{markup: MarkupBQG, faults: make(map[string]*Fault), requests: make(map[string]int)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.SetNovel(novel)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// SetNovel 修改网站上的小说，用来测试同步
+func (s *Server) SetNovel(novel Novel) {
+	if novel.Cover == nil {
+		novel.Cover = DefaultCover
+	}
+	if novel.LastUpdateTime.IsZero() {
+		novel.LastUpdateTime = time.Date(2019, 7, 1, 8, 0, 0, 0, time.Local)
+	}
+	novel.Chapters = append([]Chapter(nil), novel.Chapters...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.novel = novel
+}
+
+// SetFault 在path上注入故障，path为MENU_PATH，ChapterPath(i)等
+func (s *Server) SetFault(path string, fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[path] = &fault
+}
+
+// ClearFaults 删除所有的故障
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]*Fault)
+}
+
+// Requests 返回path被请求的次数
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// ChapterPath 返回第i个章节的路径，i从0开始
+func ChapterPath(i int) string {
+	return MENU_PATH + strconv.Itoa(i+1) + HTML_SUFFIX
+}
+
+// URL 返回host上path的完整url，通过代理访问的时候使用真实网站的host
+func URL(host string, path string) string {
+	return "http://" + host + path
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		http.Error(w, "https is not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	fault := s.takeFaultLocked(r.URL.Path)
+	novel := s.novel
+	s.mu.Unlock()
+
+	if fault != nil && fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault != nil && fault.StatusCode != 0 {
+		http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+		return
+	}
+
+	contentType, body, ok := s.render(&novel, r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if fault != nil && fault.Truncate {
+		w.Write(body[:len(body)/2])
+		panic(http.ErrAbortHandler) //不发送剩下的内容，直接断开连接
+	}
+	w.Write(body)
+}
+
+// takeFaultLocked 返回path上这次请求需要发生的故障
+func (s *Server) takeFaultLocked(path string) *Fault {
+	fault, ok := s.faults[path]
+	if !ok {
+		return nil
+	}
+	if fault.Times > 0 {
+		if fault.Times--; fault.Times == 0 {
+			delete(s.faults, path)
+		}
+	}
+	return fault
+}
+
+// render 返回r请求的页面，页面不存在的时候ok为false
+func (s *Server) render(novel *Novel, r *http.Request) (contentType string, body []byte, ok bool) {
+	var page string
+	path := r.URL.Path
+	switch {
+	case path == COVER_PATH:
+		return "image/png", novel.Cover, true
+	case path == INDEX_PATH:
+		page = renderIndex(novel, s.gbk)
+	case path == MENU_PATH:
+		page = renderMenu(novel, s.gbk)
+	case strings.HasPrefix(path, MENU_PATH) && strings.HasSuffix(path, HTML_SUFFIX):
+		i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, MENU_PATH), HTML_SUFFIX))
+		if err != nil || i < 1 || i > len(novel.Chapters) {
+			return
+		}
+		page = renderChapter(novel, i-1, s.gbk)
+	case path == SEARCH_PATH || len(r.URL.RawQuery) > 0:
+		page = renderSearch(novel, s.markup, s.searchFound(novel, r), s.gbk)
+	default:
+		return
+	}
+
+	if !s.gbk {
+		return "text/html; charset=utf-8", []byte(page), true
+	}
+	encoded, err := tool.ConvertUTF8ToGBK(page)
+	if err != nil {
+		return
+	}
+	return "text/html", []byte(encoded), true
+}
+
+// searchFound 判断查询参数中是否包含小说的名称，gbk网站的查询参数也是gbk编码的
+func (s *Server) searchFound(novel *Novel, r *http.Request) bool {
+	for _, values := range r.URL.Query() {
+		for _, value := range values {
+			if s.gbk {
+				value, _ = tool.ConvertGBKToUTF8(value)
+			}
+			if strings.TrimSpace(value) == novel.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultCover 是默认的封面图片
+var DefaultCover = func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{0xc0, 0x30, 0x30, 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(fmt.Sprintf("encode default cover fail: %v", err))
+	}
+	return buf.Bytes()
+}()
@@ -0,0 +1,106 @@
+package noveltest
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// 最新章节列表中显示的章节数，和笔趣阁一样会在正文目录前面重复出现
+const LATEST_CHAPTER_COUNT = 3
+
+func charsetMeta(gbk bool) string {
+	if gbk {
+		return `<meta http-equiv="Content-Type" content="text/html; charset=gbk" />`
+	}
+	return `<meta http-equiv="Content-Type" content="text/html; charset=utf-8" />`
+}
+
+func renderIndex(novel *Novel, gbk bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head>%s<title>假小说网</title></head>\n<body>\n", charsetMeta(gbk))
+	fmt.Fprintf(&b, `<form id="bdcs-search-form" action="%s" method="get">`+"\n", SEARCH_PATH)
+	b.WriteString(`<input name="type" value="articlename" type="hidden">` + "\n")
+	b.WriteString(`<input class="search" name=s placeholder="搜索书名" type="text">` + "\n")
+	b.WriteString("</form>\n")
+	fmt.Fprintf(&b, `<a href="%s">%s</a>`+"\n</body></html>\n", MENU_PATH, html.EscapeString(novel.Name))
+	return b.String()
+}
+
+func renderMenu(novel *Novel, gbk bool) string {
+	var b strings.Builder
+	name := html.EscapeString(novel.Name)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head>%s<title>%s最新章节列表</title></head>\n<body>\n", charsetMeta(gbk), name)
+
+	b.WriteString("<div id=\"info\">\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", name)
+	fmt.Fprintf(&b, "<p>作&nbsp;&nbsp;者：%s</p>\n", html.EscapeString(novel.Author))
+	b.WriteString("<p>状&nbsp;&nbsp;态：连载中</p>\n")
+	fmt.Fprintf(&b, "<p>最后更新：%s</p>\n", novel.LastUpdateTime.Format(DATE_LAYOUT))
+	if n := len(novel.Chapters); n > 0 {
+		fmt.Fprintf(&b, "<p>最新章节：<a href=\"%d%s\">%s</a></p>\n", n, HTML_SUFFIX, html.EscapeString(novel.Chapters[n-1].Title))
+	}
+	b.WriteString("</div>\n")
+
+	fmt.Fprintf(&b, "<div id=\"intro\">\n<p>%s</p>\n</div>\n", strings.ReplaceAll(html.EscapeString(novel.Description), "\n", "<br/>"))
+	fmt.Fprintf(&b, "<div id=\"fmimg\"><img alt=\"%s\" src=\"%s\" width=\"120\" height=\"150\" /></div>\n", name, COVER_PATH)
+
+	b.WriteString("<div id=\"list\">\n<dl>\n")
+	latest := len(novel.Chapters) - LATEST_CHAPTER_COUNT
+	if latest < 0 {
+		latest = 0
+	}
+	fmt.Fprintf(&b, "<dt>《%s》最新章节</dt>\n", name)
+	for i := len(novel.Chapters) - 1; i >= latest; i-- {
+		writeMenuItem(&b, novel, i)
+	}
+	fmt.Fprintf(&b, "<dt>《%s》正文</dt>\n", name)
+	for i := range novel.Chapters {
+		writeMenuItem(&b, novel, i)
+	}
+	b.WriteString("</dl>\n</div>\n</body></html>\n")
+	return b.String()
+}
+
+func writeMenuItem(b *strings.Builder, novel *Novel, i int) {
+	fmt.Fprintf(b, "<dd><a href=\"%d%s\">%s</a></dd>\n", i+1, HTML_SUFFIX, html.EscapeString(novel.Chapters[i].Title))
+}
+
+func renderChapter(novel *Novel, i int, gbk bool) string {
+	var b strings.Builder
+	chapter := novel.Chapters[i]
+	title := html.EscapeString(chapter.Title)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head>%s<title>%s_%s</title></head>\n<body>\n", charsetMeta(gbk), title,
+		html.EscapeString(novel.Name))
+	fmt.Fprintf(&b, "<div class=\"bookname\">\n<h1>%s</h1>\n</div>\n", title)
+
+	paragraphs := make([]string, 0)
+	for _, line := range strings.Split(chapter.Content, "\n") {
+		paragraphs = append(paragraphs, "&nbsp;&nbsp;&nbsp;&nbsp;"+html.EscapeString(line))
+	}
+	fmt.Fprintf(&b, "<div id=\"content\">%s</div>\n", strings.Join(paragraphs, "<br />\n"))
+	b.WriteString("<script>read_ad();</script>\n</body></html>\n")
+	return b.String()
+}
+
+func renderSearch(novel *Novel, markup Markup, found bool, gbk bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head>%s<title>搜索结果</title></head>\n<body>\n", charsetMeta(gbk))
+	if !found {
+		b.WriteString("<p>没有找到相关的小说</p>\n</body></html>\n")
+		return b.String()
+	}
+
+	name := html.EscapeString(novel.Name)
+	switch markup {
+	case MarkupXBQG:
+		fmt.Fprintf(&b, "<div class=\"result-game-item-detail\">\n<h3 class=\"result-item-title result-game-item-title\">\n")
+		fmt.Fprintf(&b, "<a cpos=\"title\" href=\"%s\" title=\"%s\" class=\"result-game-item-title-link\" target=\"_blank\">\n", MENU_PATH, name)
+		fmt.Fprintf(&b, "<span>%s</span>\n</a>\n</h3>\n</div>\n", name)
+	default:
+		fmt.Fprintf(&b, "<table class=\"grid\">\n<tr>\n<td class=\"odd\"><a href=\"%s\" target=\"_blank\">%s</a></td>\n", MENU_PATH, name)
+		fmt.Fprintf(&b, "<td class=\"even\">%s</td>\n</tr>\n</table>\n", html.EscapeString(novel.Author))
+	}
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"time"
 
 	"github.com/twoflyliu/novel/engine"
-	_ "github.com/twoflyliu/novel/extracter"
+	extracter "github.com/twoflyliu/novel/extracter"
 )
 
 type SearchResult struct {
@@ -16,27 +18,30 @@ type SearchResult struct {
 }
 
 func main() {
-	var verbose bool
-	var iconDirName, iconExt string
-	var logDirName string
-	flag.BoolVar(&verbose, "verbose", false, "enable debug information")
-	flag.StringVar(&iconDirName, "id", "./icons", "icon directory name")
-	flag.StringVar(&iconExt, "ie", ".img", "icon extension name")
-	flag.StringVar(&logDirName, "ld", ".", "base dir name")
+	configFlags := engine.RegisterConfigFlags(flag.CommandLine, engine.FileConfig{IconDir: "./icons",
+		IconSuffix: ".img", BaseDir: "."})
 	flag.Parse()
 
 	if flag.NArg() == 0 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-verbose] [-id 最终图标保存的目录名] [-ie 图标拓展名] [-ld 记录目录名称] 小说名称", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-c 配置文件] [-verbose] [-id 最终图标保存的目录名] [-ie 图标拓展名] [-ld 记录目录名称] 小说名称", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if len(logDirName) > 1 && logDirName[len(logDirName)-1] == '/' {
-		logDirName = logDirName[0 : len(logDirName)-1]
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg, err := configFlags.Config()
+	if err == nil {
+		_, err = extracter.RegisterSitesConfig(cfg.SitesConfig, cfg.BaseDir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
-	mgr := engine.NewDefaultEngine(verbose, "", "", iconDirName, iconExt, logDirName)
+	mgr := engine.NewEngine(cfg.Options()...)
 
-	urls := mgr.SearchSite(flag.Arg(0))
+	urls := mgr.SearchSite(ctx, flag.Arg(0))
 	log := mgr.GetLogger()
 
 	log.Debugf("urls:[%v]", urls)
@@ -45,7 +50,7 @@ func main() {
 	log.Debug("=================================================")
 	for _, url := range urls {
 		start := time.Now().UnixNano()
-		novel, err := mgr.BaseInfoByURL(url)
+		novel, err := mgr.BaseInfoByURL(ctx, url)
 
 		end := time.Now().UnixNano()
 
@@ -79,14 +84,11 @@ func main() {
 	}
 
 	// 下载小说对应的图标, 先写出图标
-	mgr.DownloadAndSaveIcon(results[minPos].novel)
+	mgr.DownloadAndSaveIcon(ctx, results[minPos].novel)
 
 	// 然后输出搜索结果
-	if len(iconExt) > 0 && iconExt[0] != '.' {
-		iconExt = "." + iconExt
-	}
 	fmt.Printf("%s|%s|%s|%s|%s|%s|%s\n", results[minPos].novel.MenuURL, results[minPos].novel.Name,
 		results[minPos].novel.Author, results[minPos].novel.Description, results[minPos].novel.LastUpdateTime,
 		results[minPos].novel.NewestLastChapterName,
-		iconDirName+"/"+results[minPos].novel.Name+iconExt)
+		mgr.IconPath(results[minPos].novel.Name))
 }
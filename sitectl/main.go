@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/twoflyliu/novel/engine"
+	extracter "github.com/twoflyliu/novel/extracter"
+)
+
+const (
+	MAX_VALUE_LINES = 5   //不使用-v的时候每个字段最多显示的行数
+	MAX_LINE_LEN    = 120 //不使用-v的时候每一行最多显示的字符数
+)
+
+// 检查sites.json中的网站定义，使用Extracter的所有方法提取一个在线或者保存的页面
+func main() {
+	configFlags := engine.RegisterConfigFlags(flag.CommandLine, engine.FileConfig{BaseDir: "."})
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 || flag.Arg(0) != "test" {
+		usage()
+		os.Exit(1)
+	}
+
+	testFlags := flag.NewFlagSet("test", flag.ExitOnError)
+	kind := testFlags.String("kind", string(extracter.PageMenu), "kind of the page: menu, chapter, index or search")
+	name := testFlags.String("name", "", "novel name to look for in a search page")
+	verbose := testFlags.Bool("v", false, "print full values and the patterns of every field")
+	testFlags.Usage = func() {
+		usage()
+		testFlags.PrintDefaults()
+	}
+	testFlags.Parse(flag.Args()[1:])
+	if testFlags.NArg() != 2 {
+		testFlags.Usage()
+		os.Exit(1)
+	}
+	site, location := testFlags.Arg(0), testFlags.Arg(1)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg, err := configFlags.Config()
+	CheckError(err)
+	config, source, err := extracter.FindSitesConfig(cfg.SitesConfig, cfg.BaseDir)
+	CheckError(err)
+	CheckError(config.Register())
+
+	cfg.NoCache = true //检查的是网站现在的页面
+	mgr := engine.NewEngine(cfg.Options()...)
+
+	test := &extracter.PageTest{Site: site, Kind: extracter.PageKind(*kind), NovelName: *name}
+	var page string
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		test.URL = location
+		page, err = engine.DownloadText(ctx, mgr.GetDownloader(), location, 0)
+	} else {
+		page, err = readPage(location)
+	}
+	CheckError(err)
+
+	reports, err := config.TestPage(test, page)
+	CheckError(err)
+	extracterName, _ := config.ResolveExtracter(site)
+	fmt.Printf("sites: %s\nextracter: %s\npage: %s (%s)\n\n", source, extracterName, location, test.Kind)
+
+	problems := 0
+	for _, report := range reports {
+		if report.Status == extracter.FieldSkipped && !*verbose {
+			continue
+		}
+		fmt.Printf("[%s] %s\n", report.Status, report.Field)
+		if len(report.Value) > 0 {
+			printValue(report.Value, *verbose)
+		}
+		if len(report.Problem) > 0 {
+			problems++
+			fmt.Printf("    problem: %s\n", report.Problem)
+		}
+		if len(report.Pattern) > 0 && (len(report.Problem) > 0 || *verbose) {
+			fmt.Printf("    pattern: %s\n", report.Pattern)
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("\n%d problems found\n", problems)
+		os.Exit(2)
+	}
+}
+
+// readPage 读取保存的页面，并且转换成utf-8
+func readPage(path string) (string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	resp := &engine.Response{URL: path, Body: body}
+	return resp.Text()
+}
+
+// printValue 缩进显示字段的值，不是verbose的时候省略过多的行和过长的行
+func printValue(value string, verbose bool) {
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		if !verbose && i == MAX_VALUE_LINES {
+			fmt.Printf("    ... %d more lines\n", len(lines)-i)
+			break
+		}
+		if runes := []rune(line); !verbose && len(runes) > MAX_LINE_LEN {
+			line = string(runes[:MAX_LINE_LEN]) + "..."
+		}
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-c config] [-sites sites.json] test [-kind menu|chapter|index|search] [-name novel] [-v] site url|file\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "site is the name of an extracter in sites.json, or a host matched by RegistryExtracterList")
+	flag.PrintDefaults()
+}
+
+func CheckError(err error) {
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
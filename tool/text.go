@@ -2,12 +2,13 @@ package tool
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 
-	"strings"
-
+	"golang.org/x/text/encoding/htmlindex"
 	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
 
@@ -24,15 +25,37 @@ func ConvertGBKToUTF8(str string) (string, error) {
 }
 
 func ConvertToUTF8(str string, charset string) (string, error) {
-	charset = strings.ToUpper(charset)
-	if len(charset) >= 2 && charset[0:2] == "GB" {
-		return ConvertGBKToUTF8(str)
+	return DecodeToUTF8([]byte(str), charset)
+}
+
+// DecodeToUTF8 将charset编码的data转换成utf-8的字符串
+// charset使用html规范中的名称，比如gbk, gb2312, big5, shift_jis，为空的时候表示utf-8
+func DecodeToUTF8(data []byte, charset string) (string, error) {
+	if len(charset) == 0 {
+		charset = "utf-8"
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", fmt.Errorf("unsupported charset %q", charset)
 	}
-	return "", nil
+	if enc == unicode.UTF8 {
+		return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), nil
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	return string(decoded), err
+}
+
+// IsSupportedCharset 判断DecodeToUTF8是否支持charset
+func IsSupportedCharset(charset string) bool {
+	_, err := htmlindex.Get(charset)
+	return err == nil
 }
 
 // 将gbk中文转义为他们的以%ascii值形式
 func EscapeString(gbk string) string {
-	u, _ := url.Parse(gbk)
+	u, err := url.Parse(gbk)
+	if err != nil {
+		return url.QueryEscape(gbk)
+	}
 	return u.String()
 }